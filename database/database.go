@@ -10,6 +10,7 @@ import (
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/rs/zerolog/log"
 )
@@ -30,6 +31,12 @@ type Service interface {
 	SetDisconnected(id int) error
 	SetJid(id int, jid string) error
 	SetEvents(id int, events string) error
+	SetPresence(id int, presence string) error
+	SetDisappearingTimer(id int, seconds int) error
+	// SetLastMessages guarda as chaves das últimas mensagens dos chats, sem substituir mensagens mais novas
+	SetLastMessages(messages []*LastMessage) error
+	GetLastMessage(userID uint, chat string) (*LastMessage, error)
+	DeleteLastMessage(userID uint, chat string) error
 	GetUserById(id int) (*User, error)
 	GetUserByToken(token string) (*User, error)
 	// ListConnectedUsers retorna todos os usuários conectados
@@ -43,25 +50,27 @@ type Service interface {
 
 type User struct {
 	gorm.Model
-	ID               uint   `gorm:"primaryKey"`
-	Name             string `gorm:"type:text;not null;index"`
-	Token            string `gorm:"type:text;not null;index"`
-	Webhook          string `gorm:"type:text;not null;default:''"`
-	Jid              string `gorm:"type:text;not null;default:''"`
-	Qrcode           string `gorm:"type:text;not null;default:''"`
-	Connected        int    `gorm:"type:integer;index"`
-	Expiration       int    `gorm:"type:integer"`
-	Events           string `gorm:"type:text;not null;default:'All'"`
-	PairingCode      string `gorm:"type:text;not null;default:''"`
-	Instance         string `gorm:"type:text;not null;default:''"`
-	CountTextMsg     int    `gorm:"type:integer;default:0"`
-	CountImageMsg    int    `gorm:"type:integer;default:0"`
-	CountVoiceMsg    int    `gorm:"type:integer;default:0"`
-	CountVideoMsg    int    `gorm:"type:integer;default:0"`
-	CountStickerMsg  int    `gorm:"type:integer;default:0"`
-	CountLocationMsg int    `gorm:"type:integer;default:0"`
-	CountContactMsg  int    `gorm:"type:integer;default:0"`
-	CountDocumentMsg int    `gorm:"type:integer;default:0"`
+	ID                uint   `gorm:"primaryKey"`
+	Name              string `gorm:"type:text;not null;index"`
+	Token             string `gorm:"type:text;not null;index"`
+	Webhook           string `gorm:"type:text;not null;default:''"`
+	Jid               string `gorm:"type:text;not null;default:''"`
+	Qrcode            string `gorm:"type:text;not null;default:''"`
+	Connected         int    `gorm:"type:integer;index"`
+	Expiration        int    `gorm:"type:integer"`
+	Events            string `gorm:"type:text;not null;default:'All'"`
+	Presence          string `gorm:"type:text;not null;default:''"`
+	DisappearingTimer int    `gorm:"type:integer;not null;default:0"`
+	PairingCode       string `gorm:"type:text;not null;default:''"`
+	Instance          string `gorm:"type:text;not null;default:''"`
+	CountTextMsg      int    `gorm:"type:integer;default:0"`
+	CountImageMsg     int    `gorm:"type:integer;default:0"`
+	CountVoiceMsg     int    `gorm:"type:integer;default:0"`
+	CountVideoMsg     int    `gorm:"type:integer;default:0"`
+	CountStickerMsg   int    `gorm:"type:integer;default:0"`
+	CountLocationMsg  int    `gorm:"type:integer;default:0"`
+	CountContactMsg   int    `gorm:"type:integer;default:0"`
+	CountDocumentMsg  int    `gorm:"type:integer;default:0"`
 }
 
 type UserHistory struct {
@@ -83,6 +92,16 @@ type UserHistory struct {
 	ConnectedAt      *time.Time `gorm:"type:timestamp;default:null"`
 }
 
+// The newest message of a chat, which app state changes such as archiving or clearing the chat refer to
+type LastMessage struct {
+	UserID    uint   `gorm:"primaryKey;autoIncrement:false"`
+	Chat      string `gorm:"primaryKey;type:varchar(255)"`
+	MessageID string `gorm:"type:text;not null"`
+	Sender    string `gorm:"type:text;not null;default:''"`
+	FromMe    bool   `gorm:"type:boolean;default:false"`
+	Timestamp int64  `gorm:"type:bigint;not null"`
+}
+
 type service struct {
 	db *gorm.DB
 }
@@ -156,7 +175,7 @@ func startSqlite(exPath string) (*gorm.DB, string, error) {
 		return nil, "", err
 	}
 
-	db.AutoMigrate(&User{}, &UserHistory{})
+	db.AutoMigrate(&User{}, &UserHistory{}, &LastMessage{})
 
 	return db, exPath + "/dbdata/users.db", nil
 }
@@ -175,7 +194,7 @@ func NewService(exPath string, driver string) (Service, string, error) {
 		db, connString, err = startSqlite(exPath)
 	}
 
-	db.AutoMigrate(&User{}, &UserHistory{})
+	db.AutoMigrate(&User{}, &UserHistory{}, &LastMessage{})
 
 	if err != nil {
 		return nil, "", err
@@ -293,6 +312,97 @@ func (s *service) SetEvents(id int, events string) error {
 	return nil
 }
 
+func (s *service) SetPresence(id int, presence string) error {
+
+	err := s.db.Model(&User{}).Where("id = ?", id).Update("presence", presence).Error
+
+	if err != nil {
+		log.Error().Err(err).Msg("Could not set presence")
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *service) SetDisappearingTimer(id int, seconds int) error {
+
+	err := s.db.Model(&User{}).Where("id = ?", id).Update("disappearing_timer", seconds).Error
+
+	if err != nil {
+		log.Error().Err(err).Msg("Could not set disappearing timer")
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *service) SetLastMessages(messages []*LastMessage) error {
+
+	// Messages can arrive out of order, e.g. when history is synced after a restart, so a saved message is only replaced
+	// by a newer one. This is an update followed by an insert, as MySQL has no conditional upserts
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, message := range messages {
+			result := tx.Model(&LastMessage{}).
+				Where("user_id = ? AND chat = ? AND timestamp < ?", message.UserID, message.Chat, message.Timestamp).
+				Updates(map[string]interface{}{
+					"message_id": message.MessageID,
+					"sender":     message.Sender,
+					"from_me":    message.FromMe,
+					"timestamp":  message.Timestamp,
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				continue
+			}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(message).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		log.Error().Err(err).Msg("Could not set last messages")
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *service) GetLastMessage(userID uint, chat string) (*LastMessage, error) {
+	var messages []LastMessage
+
+	// Find instead of First, chats without a saved message are common and not worth logging
+	err := s.db.Where("user_id = ? AND chat = ?", userID, chat).Limit(1).Find(&messages).Error
+
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return &messages[0], nil
+}
+
+func (s *service) DeleteLastMessage(userID uint, chat string) error {
+
+	err := s.db.Where("user_id = ? AND chat = ?", userID, chat).Delete(&LastMessage{}).Error
+
+	if err != nil {
+		log.Error().Err(err).Msg("Could not delete last message")
+
+		return err
+	}
+
+	return nil
+}
+
 func (s *service) SetPairingCode(id int, pairingCode string, instance string) error {
 
 	err := s.db.Model(&User{}).Where("id = ?", id).Where("instance = ?", instance).Update("pairing_code", pairingCode).Error
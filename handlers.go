@@ -1,12 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -15,9 +19,11 @@ import (
 	"github.com/patrickmn/go-cache"
 	"github.com/vincent-petithory/dataurl"
 	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
 	"go.mau.fi/whatsmeow/proto/waCommon"
 	"go.mau.fi/whatsmeow/proto/waE2E"
 	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -29,7 +35,7 @@ func (v Values) Get(key string) string {
 	return v.m[key]
 }
 
-var messageTypes = []string{"Message", "ReadReceipt", "Presence", "HistorySync", "ChatPresence", "All"}
+var messageTypes = []string{"Message", "Edited", "Revoked", "PollVote", "InteractiveReply", "LiveLocation", "Status", "Newsletter", "JoinRequest", "Group", "PrivacySettings", "Blocklist", "ChatState", "Label", "ReadReceipt", "Presence", "HistorySync", "ChatPresence", "All"}
 
 var secret_paths = []string{"/users/create", "/users/delete"}
 
@@ -684,16 +690,75 @@ func (s *server) GetStatus() http.HandlerFunc {
 	}
 }
 
+// Uploads media to WhatsApp servers and returns a handle that can be reused in send calls
+func (s *server) UploadMedia() http.HandlerFunc {
+
+	type uploadStruct struct {
+		Type     string
+		Media    string
+		Url      string
+		FileName string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		var t uploadStruct
+		upload, err := decodeMediaPayload(w, r, &t, "Media")
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if _, ok := mediaKinds[t.Type]; !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("type must be one of image, video, audio, document or sticker"))
+			return
+		}
+
+		prepared, status, err := getSendMedia(userid, upload, t.Media, t.Url, "", t.FileName, t.Type)
+		if err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
+
+		response := map[string]interface{}{"MediaId": prepared.MediaId, "Type": t.Type, "Mimetype": prepared.Mimetype, "FileName": prepared.FileName, "FileLength": prepared.FileLength}
+		if prepared.Width > 0 {
+			response["Width"] = prepared.Width
+			response["Height"] = prepared.Height
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
 // Sends a document/attachment message
 func (s *server) SendDocument() http.HandlerFunc {
 
 	type documentStruct struct {
-		Phone       string
-		Document    string
-		Caption     string
-		FileName    string
-		Id          string
-		ContextInfo waE2E.ContextInfo
+		Phone         string
+		Document      string
+		Url           string
+		MediaId       string
+		Caption       string
+		FileName      string
+		Mimetype      string
+		JpegThumbnail []byte
+		Id            string
+		ContextInfo   waE2E.ContextInfo
+		Mentions      []string
+		MentionAll    bool
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -708,11 +773,10 @@ func (s *server) SendDocument() http.HandlerFunc {
 			return
 		}
 
-		decoder := json.NewDecoder(r.Body)
 		var t documentStruct
-		err := decoder.Decode(&t)
+		upload, err := decodeMediaPayload(w, r, &t, "Document")
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
@@ -721,22 +785,40 @@ func (s *server) SendDocument() http.HandlerFunc {
 			return
 		}
 
-		if t.Document == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing document in payload"))
+		contextInfo, err := buildContextInfo(userid, &t.ContextInfo)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		recipient, err := validateMessageFields(t.Phone, contextInfo.GetStanzaID(), contextInfo.GetParticipant())
+		if err != nil {
+			log.Error().Msg(fmt.Sprintf("%s", err))
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
-		if t.FileName == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing filename in payload"))
+		contextInfo, status, err := addMentions(userid, contextInfo, recipient, t.Caption, t.Mentions, t.MentionAll)
+		if err != nil {
+			s.Respond(w, r, status, err)
 			return
 		}
 
-		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.GetStanzaID(), t.ContextInfo.GetParticipant())
+		prepared, status, err := getSendMedia(userid, upload, t.Document, t.Url, t.MediaId, t.FileName, "document")
 		if err != nil {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+			s.Respond(w, r, status, err)
+			return
+		}
+
+		if prepared.FileName == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing filename in payload"))
 			return
 		}
+		if t.Mimetype != "" {
+			prepared.Mimetype = t.Mimetype
+		}
+		if t.JpegThumbnail != nil {
+			prepared.Thumbnail = t.JpegThumbnail
+		}
 
 		if t.Id == "" {
 			msgid = whatsmeow.GenerateMessageID()
@@ -744,52 +826,29 @@ func (s *server) SendDocument() http.HandlerFunc {
 			msgid = t.Id
 		}
 
-		var uploaded whatsmeow.UploadResponse
-		var filedata []byte
-
-		if t.Document[0:29] == "data:application/octet-stream" {
-			dataURL, err := dataurl.DecodeString(t.Document)
-			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode base64 encoded data from payload"))
-				return
-			} else {
-				filedata = dataURL.Data
-				uploaded, err = clientPointer[userid].Upload(context.Background(), filedata, whatsmeow.MediaDocument)
-				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to upload file: %v", err)))
-					return
-				}
-			}
-		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("document data should start with \"data:application/octet-stream;base64,\""))
-			return
-		}
-
 		msg := &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{
-			URL:           proto.String(uploaded.URL),
+			URL:           proto.String(prepared.URL),
 			Caption:       proto.String(t.Caption),
-			FileName:      &t.FileName,
-			DirectPath:    proto.String(uploaded.DirectPath),
-			MediaKey:      uploaded.MediaKey,
-			Mimetype:      proto.String(http.DetectContentType(filedata)),
-			FileEncSHA256: uploaded.FileEncSHA256,
-			FileSHA256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
+			FileName:      proto.String(prepared.FileName),
+			DirectPath:    proto.String(prepared.DirectPath),
+			MediaKey:      prepared.MediaKey,
+			Mimetype:      proto.String(prepared.Mimetype),
+			FileEncSHA256: prepared.FileEncSHA256,
+			FileSHA256:    prepared.FileSHA256,
+			FileLength:    proto.Uint64(prepared.FileLength),
+			JPEGThumbnail: prepared.Thumbnail,
 		}}
 
-		if t.ContextInfo.GetStanzaID() != "" {
-			msg.ExtendedTextMessage.ContextInfo = &waE2E.ContextInfo{
-				StanzaID:      proto.String(t.ContextInfo.GetStanzaID()),
-				Participant:   proto.String(t.ContextInfo.GetParticipant()),
-				QuotedMessage: &waE2E.Message{Conversation: proto.String("")},
-			}
-		}
+		setContextInfo(msg, contextInfo)
+		applyDisappearingTimer(userid, recipient, msg)
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
+			forgetUploadedMedia(userid, prepared)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
 			return
 		}
+		storeSentMessage(userid, recipient, resp, msg)
 
 		// log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
@@ -813,7 +872,10 @@ func (s *server) SendAudio() http.HandlerFunc {
 	type audioStruct struct {
 		Phone       string
 		Audio       string
+		Url         string
+		MediaId     string
 		Caption     string
+		Mimetype    string
 		Id          string
 		ContextInfo waE2E.ContextInfo
 	}
@@ -830,11 +892,10 @@ func (s *server) SendAudio() http.HandlerFunc {
 			return
 		}
 
-		decoder := json.NewDecoder(r.Body)
 		var t audioStruct
-		err := decoder.Decode(&t)
+		upload, err := decodeMediaPayload(w, r, &t, "Audio")
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
@@ -843,73 +904,63 @@ func (s *server) SendAudio() http.HandlerFunc {
 			return
 		}
 
-		if t.Audio == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing audio in payload"))
+		contextInfo, err := buildContextInfo(userid, &t.ContextInfo)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
-
-		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.GetStanzaID(), t.ContextInfo.GetParticipant())
+		recipient, err := validateMessageFields(t.Phone, contextInfo.GetStanzaID(), contextInfo.GetParticipant())
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
 			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
+		prepared, status, err := getSendMedia(userid, upload, t.Audio, t.Url, t.MediaId, "", "audio")
+		if err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
+
 		if t.Id == "" {
 			msgid = whatsmeow.GenerateMessageID()
 		} else {
 			msgid = t.Id
 		}
 
-		var uploaded whatsmeow.UploadResponse
-		var filedata []byte
-
-		if t.Audio[0:14] == "data:audio/ogg" {
-			dataURL, err := dataurl.DecodeString(t.Audio)
-			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode base64 encoded data from payload"))
-				return
-			} else {
-				filedata = dataURL.Data
-				uploaded, err = clientPointer[userid].Upload(context.Background(), filedata, whatsmeow.MediaAudio)
-				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to upload file: %v", err)))
-					return
-				}
-			}
-		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("audio data should start with \"data:audio/ogg;base64,\""))
-			return
+		mimetype := prepared.Mimetype
+		if t.Mimetype != "" {
+			mimetype = t.Mimetype
 		}
 
-		ptt := true
-		mime := "audio/ogg; codecs=opus"
+		// Only Opus in an Ogg container can be played as a voice note
+		ptt := false
+		if strings.HasPrefix(mimetype, "audio/ogg") || mimetype == "application/ogg" {
+			ptt = true
+			mimetype = "audio/ogg; codecs=opus"
+		}
 
 		msg := &waE2E.Message{AudioMessage: &waE2E.AudioMessage{
-			URL:        proto.String(uploaded.URL),
-			DirectPath: proto.String(uploaded.DirectPath),
-			MediaKey:   uploaded.MediaKey,
-			//Mimetype:      proto.String(http.DetectContentType(filedata)),
-			Mimetype:      &mime,
-			FileEncSHA256: uploaded.FileEncSHA256,
-			FileSHA256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
+			URL:           proto.String(prepared.URL),
+			DirectPath:    proto.String(prepared.DirectPath),
+			MediaKey:      prepared.MediaKey,
+			Mimetype:      proto.String(mimetype),
+			FileEncSHA256: prepared.FileEncSHA256,
+			FileSHA256:    prepared.FileSHA256,
+			FileLength:    proto.Uint64(prepared.FileLength),
 			PTT:           &ptt,
 		}}
 
-		if t.ContextInfo.GetStanzaID() != "" {
-			msg.ExtendedTextMessage.ContextInfo = &waE2E.ContextInfo{
-				StanzaID:      proto.String(t.ContextInfo.GetStanzaID()),
-				Participant:   proto.String(t.ContextInfo.GetParticipant()),
-				QuotedMessage: &waE2E.Message{Conversation: proto.String("")},
-			}
-		}
+		setContextInfo(msg, contextInfo)
+		applyDisappearingTimer(userid, recipient, msg)
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
+			forgetUploadedMedia(userid, prepared)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
 			return
 		}
+		storeSentMessage(userid, recipient, resp, msg)
 
 		// log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
@@ -931,11 +982,17 @@ func (s *server) SendAudio() http.HandlerFunc {
 func (s *server) SendImage() http.HandlerFunc {
 
 	type imageStruct struct {
-		Phone       string
-		Image       string
-		Caption     string
-		Id          string
-		ContextInfo waE2E.ContextInfo
+		Phone         string
+		Image         string
+		Url           string
+		MediaId       string
+		Caption       string
+		Mimetype      string
+		JpegThumbnail []byte
+		Id            string
+		ContextInfo   waE2E.ContextInfo
+		Mentions      []string
+		MentionAll    bool
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -950,11 +1007,10 @@ func (s *server) SendImage() http.HandlerFunc {
 			return
 		}
 
-		decoder := json.NewDecoder(r.Body)
 		var t imageStruct
-		err := decoder.Decode(&t)
+		upload, err := decodeMediaPayload(w, r, &t, "Image")
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
@@ -963,69 +1019,70 @@ func (s *server) SendImage() http.HandlerFunc {
 			return
 		}
 
-		if t.Image == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing image in payload"))
+		contextInfo, err := buildContextInfo(userid, &t.ContextInfo)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
-
-		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.GetStanzaID(), t.ContextInfo.GetParticipant())
+		recipient, err := validateMessageFields(t.Phone, contextInfo.GetStanzaID(), contextInfo.GetParticipant())
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
 			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
+		contextInfo, status, err := addMentions(userid, contextInfo, recipient, t.Caption, t.Mentions, t.MentionAll)
+		if err != nil {
+			s.Respond(w, r, status, err)
+			return
 		}
 
-		var uploaded whatsmeow.UploadResponse
-		var filedata []byte
+		prepared, status, err := getSendMedia(userid, upload, t.Image, t.Url, t.MediaId, "", "image")
+		if err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
 
-		if t.Image[0:10] == "data:image" {
-			dataURL, err := dataurl.DecodeString(t.Image)
-			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode base64 encoded data from payload"))
-				return
-			} else {
-				filedata = dataURL.Data
-				uploaded, err = clientPointer[userid].Upload(context.Background(), filedata, whatsmeow.MediaImage)
-				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to upload file: %v", err)))
-					return
-				}
-			}
+		if t.Mimetype != "" {
+			prepared.Mimetype = t.Mimetype
+		}
+		if t.JpegThumbnail != nil {
+			prepared.Thumbnail = t.JpegThumbnail
+		}
+
+		if t.Id == "" {
+			msgid = whatsmeow.GenerateMessageID()
 		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("image data should start with \"data:image/png;base64,\""))
-			return
+			msgid = t.Id
 		}
 
 		msg := &waE2E.Message{ImageMessage: &waE2E.ImageMessage{
 			Caption:       proto.String(t.Caption),
-			URL:           proto.String(uploaded.URL),
-			DirectPath:    proto.String(uploaded.DirectPath),
-			MediaKey:      uploaded.MediaKey,
-			Mimetype:      proto.String(http.DetectContentType(filedata)),
-			FileEncSHA256: uploaded.FileEncSHA256,
-			FileSHA256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
+			URL:           proto.String(prepared.URL),
+			DirectPath:    proto.String(prepared.DirectPath),
+			MediaKey:      prepared.MediaKey,
+			Mimetype:      proto.String(prepared.Mimetype),
+			FileEncSHA256: prepared.FileEncSHA256,
+			FileSHA256:    prepared.FileSHA256,
+			FileLength:    proto.Uint64(prepared.FileLength),
+			JPEGThumbnail: prepared.Thumbnail,
 		}}
 
-		if t.ContextInfo.GetStanzaID() != "" {
-			msg.ExtendedTextMessage.ContextInfo = &waE2E.ContextInfo{
-				StanzaID:      proto.String(t.ContextInfo.GetStanzaID()),
-				Participant:   proto.String(t.ContextInfo.GetParticipant()),
-				QuotedMessage: &waE2E.Message{Conversation: proto.String("")},
-			}
+		if prepared.Width > 0 {
+			msg.ImageMessage.Width = proto.Uint32(prepared.Width)
+			msg.ImageMessage.Height = proto.Uint32(prepared.Height)
 		}
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		setContextInfo(msg, contextInfo)
+		applyDisappearingTimer(userid, recipient, msg)
+
+		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
+			forgetUploadedMedia(userid, prepared)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
 			return
 		}
+		storeSentMessage(userid, recipient, resp, msg)
 
 		// log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
@@ -1049,6 +1106,9 @@ func (s *server) SendSticker() http.HandlerFunc {
 	type stickerStruct struct {
 		Phone        string
 		Sticker      string
+		Url          string
+		MediaId      string
+		Mimetype     string
 		Id           string
 		PngThumbnail []byte
 		ContextInfo  waE2E.ContextInfo
@@ -1066,11 +1126,10 @@ func (s *server) SendSticker() http.HandlerFunc {
 			return
 		}
 
-		decoder := json.NewDecoder(r.Body)
 		var t stickerStruct
-		err := decoder.Decode(&t)
+		upload, err := decodeMediaPayload(w, r, &t, "Sticker")
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
@@ -1079,69 +1138,55 @@ func (s *server) SendSticker() http.HandlerFunc {
 			return
 		}
 
-		if t.Sticker == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing sticker in payload"))
+		contextInfo, err := buildContextInfo(userid, &t.ContextInfo)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
-
-		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.GetStanzaID(), t.ContextInfo.GetParticipant())
+		recipient, err := validateMessageFields(t.Phone, contextInfo.GetStanzaID(), contextInfo.GetParticipant())
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
 			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
+		prepared, status, err := getSendMedia(userid, upload, t.Sticker, t.Url, t.MediaId, "", "sticker")
+		if err != nil {
+			s.Respond(w, r, status, err)
+			return
 		}
 
-		var uploaded whatsmeow.UploadResponse
-		var filedata []byte
+		if t.Mimetype != "" {
+			prepared.Mimetype = t.Mimetype
+		}
 
-		if t.Sticker[0:4] == "data" {
-			dataURL, err := dataurl.DecodeString(t.Sticker)
-			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode base64 encoded data from payload"))
-				return
-			} else {
-				filedata = dataURL.Data
-				uploaded, err = clientPointer[userid].Upload(context.Background(), filedata, whatsmeow.MediaImage)
-				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to upload file: %v", err)))
-					return
-				}
-			}
+		if t.Id == "" {
+			msgid = whatsmeow.GenerateMessageID()
 		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("data should start with \"data:mime/type;base64,\""))
-			return
+			msgid = t.Id
 		}
 
 		msg := &waE2E.Message{StickerMessage: &waE2E.StickerMessage{
-			URL:           proto.String(uploaded.URL),
-			DirectPath:    proto.String(uploaded.DirectPath),
-			MediaKey:      uploaded.MediaKey,
-			Mimetype:      proto.String(http.DetectContentType(filedata)),
-			FileEncSHA256: uploaded.FileEncSHA256,
-			FileSHA256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
+			URL:           proto.String(prepared.URL),
+			DirectPath:    proto.String(prepared.DirectPath),
+			MediaKey:      prepared.MediaKey,
+			Mimetype:      proto.String(prepared.Mimetype),
+			FileEncSHA256: prepared.FileEncSHA256,
+			FileSHA256:    prepared.FileSHA256,
+			FileLength:    proto.Uint64(prepared.FileLength),
 			PngThumbnail:  t.PngThumbnail,
 		}}
 
-		if t.ContextInfo.GetStanzaID() != "" {
-			msg.ExtendedTextMessage.ContextInfo = &waE2E.ContextInfo{
-				StanzaID:      proto.String(t.ContextInfo.GetStanzaID()),
-				Participant:   proto.String(t.ContextInfo.GetParticipant()),
-				QuotedMessage: &waE2E.Message{Conversation: proto.String("")},
-			}
-		}
+		setContextInfo(msg, contextInfo)
+		applyDisappearingTimer(userid, recipient, msg)
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
+			forgetUploadedMedia(userid, prepared)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
 			return
 		}
+		storeSentMessage(userid, recipient, resp, msg)
 
 		// log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
@@ -1158,13 +1203,18 @@ func (s *server) SendSticker() http.HandlerFunc {
 // Sends Video message
 func (s *server) SendVideo() http.HandlerFunc {
 
-	type imageStruct struct {
+	type videoStruct struct {
 		Phone         string
 		Video         string
+		Url           string
+		MediaId       string
 		Caption       string
+		Mimetype      string
 		Id            string
 		JpegThumbnail []byte
 		ContextInfo   waE2E.ContextInfo
+		Mentions      []string
+		MentionAll    bool
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1179,11 +1229,10 @@ func (s *server) SendVideo() http.HandlerFunc {
 			return
 		}
 
-		decoder := json.NewDecoder(r.Body)
-		var t imageStruct
-		err := decoder.Decode(&t)
+		var t videoStruct
+		upload, err := decodeMediaPayload(w, r, &t, "Video")
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
@@ -1192,70 +1241,62 @@ func (s *server) SendVideo() http.HandlerFunc {
 			return
 		}
 
-		if t.Video == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing video in payload"))
+		contextInfo, err := buildContextInfo(userid, &t.ContextInfo)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
-
-		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.GetStanzaID(), t.ContextInfo.GetParticipant())
+		recipient, err := validateMessageFields(t.Phone, contextInfo.GetStanzaID(), contextInfo.GetParticipant())
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
 			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
-		if t.Id == "" {
-			msgid = whatsmeow.GenerateMessageID()
-		} else {
-			msgid = t.Id
+		contextInfo, status, err := addMentions(userid, contextInfo, recipient, t.Caption, t.Mentions, t.MentionAll)
+		if err != nil {
+			s.Respond(w, r, status, err)
+			return
 		}
 
-		var uploaded whatsmeow.UploadResponse
-		var filedata []byte
+		prepared, status, err := getSendMedia(userid, upload, t.Video, t.Url, t.MediaId, "", "video")
+		if err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
 
-		if t.Video[0:4] == "data" {
-			dataURL, err := dataurl.DecodeString(t.Video)
-			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode base64 encoded data from payload"))
-				return
-			} else {
-				filedata = dataURL.Data
-				uploaded, err = clientPointer[userid].Upload(context.Background(), filedata, whatsmeow.MediaVideo)
-				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to upload file: %v", err)))
-					return
-				}
-			}
+		if t.Mimetype != "" {
+			prepared.Mimetype = t.Mimetype
+		}
+
+		if t.Id == "" {
+			msgid = whatsmeow.GenerateMessageID()
 		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("data should start with \"data:mime/type;base64,\""))
-			return
+			msgid = t.Id
 		}
 
 		msg := &waE2E.Message{VideoMessage: &waE2E.VideoMessage{
 			Caption:       proto.String(t.Caption),
-			URL:           proto.String(uploaded.URL),
-			DirectPath:    proto.String(uploaded.DirectPath),
-			MediaKey:      uploaded.MediaKey,
-			Mimetype:      proto.String(http.DetectContentType(filedata)),
-			FileEncSHA256: uploaded.FileEncSHA256,
-			FileSHA256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
+			URL:           proto.String(prepared.URL),
+			DirectPath:    proto.String(prepared.DirectPath),
+			MediaKey:      prepared.MediaKey,
+			Mimetype:      proto.String(prepared.Mimetype),
+			FileEncSHA256: prepared.FileEncSHA256,
+			FileSHA256:    prepared.FileSHA256,
+			FileLength:    proto.Uint64(prepared.FileLength),
 			JPEGThumbnail: t.JpegThumbnail,
 		}}
 
-		if t.ContextInfo.GetStanzaID() != "" {
-			msg.ExtendedTextMessage.ContextInfo = &waE2E.ContextInfo{
-				StanzaID:      proto.String(t.ContextInfo.GetStanzaID()),
-				Participant:   proto.String(t.ContextInfo.GetParticipant()),
-				QuotedMessage: &waE2E.Message{Conversation: proto.String("")},
-			}
-		}
+		setContextInfo(msg, contextInfo)
+		applyDisappearingTimer(userid, recipient, msg)
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
+			forgetUploadedMedia(userid, prepared)
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
 			return
 		}
+		storeSentMessage(userid, recipient, resp, msg)
 
 		// log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
@@ -1281,6 +1322,7 @@ func (s *server) SendContact() http.HandlerFunc {
 		Id          string
 		Name        string
 		Vcard       string
+		Contacts    []contactCard
 		ContextInfo waE2E.ContextInfo
 	}
 
@@ -1308,16 +1350,29 @@ func (s *server) SendContact() http.HandlerFunc {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
 			return
 		}
-		if t.Name == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing name in payload"))
-			return
+		if len(t.Contacts) == 0 {
+			if t.Name == "" {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("missing name in payload"))
+				return
+			}
+			if t.Vcard == "" {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("missing vcard in payload"))
+				return
+			}
 		}
-		if t.Vcard == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing vcard in payload"))
-			return
+		for _, contact := range t.Contacts {
+			if err := contact.validate(); err != nil {
+				s.Respond(w, r, http.StatusBadRequest, err)
+				return
+			}
 		}
 
-		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.GetStanzaID(), t.ContextInfo.GetParticipant())
+		contextInfo, err := buildContextInfo(userid, &t.ContextInfo)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		recipient, err := validateMessageFields(t.Phone, contextInfo.GetStanzaID(), contextInfo.GetParticipant())
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
 			s.Respond(w, r, http.StatusBadRequest, err)
@@ -1330,24 +1385,41 @@ func (s *server) SendContact() http.HandlerFunc {
 			msgid = t.Id
 		}
 
-		msg := &waE2E.Message{ContactMessage: &waE2E.ContactMessage{
-			DisplayName: &t.Name,
-			Vcard:       &t.Vcard,
-		}}
-
-		if t.ContextInfo.GetStanzaID() != "" {
-			msg.ExtendedTextMessage.ContextInfo = &waE2E.ContextInfo{
-				StanzaID:      proto.String(t.ContextInfo.GetStanzaID()),
-				Participant:   proto.String(t.ContextInfo.GetParticipant()),
-				QuotedMessage: &waE2E.Message{Conversation: proto.String("")},
+		var msg *waE2E.Message
+		switch len(t.Contacts) {
+		case 0:
+			msg = &waE2E.Message{ContactMessage: &waE2E.ContactMessage{
+				DisplayName: &t.Name,
+				Vcard:       &t.Vcard,
+			}}
+		case 1:
+			msg = &waE2E.Message{ContactMessage: &waE2E.ContactMessage{
+				DisplayName: proto.String(t.Contacts[0].displayName()),
+				Vcard:       proto.String(t.Contacts[0].vcard()),
+			}}
+		default:
+			var contacts []*waE2E.ContactMessage
+			for _, contact := range t.Contacts {
+				contacts = append(contacts, &waE2E.ContactMessage{
+					DisplayName: proto.String(contact.displayName()),
+					Vcard:       proto.String(contact.vcard()),
+				})
 			}
+			msg = &waE2E.Message{ContactsArrayMessage: &waE2E.ContactsArrayMessage{
+				DisplayName: proto.String(fmt.Sprintf("%d contacts", len(contacts))),
+				Contacts:    contacts,
+			}}
 		}
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		setContextInfo(msg, contextInfo)
+		applyDisappearingTimer(userid, recipient, msg)
+
+		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
 			return
 		}
+		storeSentMessage(userid, recipient, resp, msg)
 
 		// log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
@@ -1410,7 +1482,12 @@ func (s *server) SendLocation() http.HandlerFunc {
 			return
 		}
 
-		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.GetStanzaID(), t.ContextInfo.GetParticipant())
+		contextInfo, err := buildContextInfo(userid, &t.ContextInfo)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		recipient, err := validateMessageFields(t.Phone, contextInfo.GetStanzaID(), contextInfo.GetParticipant())
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
 			s.Respond(w, r, http.StatusBadRequest, err)
@@ -1429,19 +1506,15 @@ func (s *server) SendLocation() http.HandlerFunc {
 			Name:             &t.Name,
 		}}
 
-		if t.ContextInfo.GetStanzaID() != "" {
-			msg.ExtendedTextMessage.ContextInfo = &waE2E.ContextInfo{
-				StanzaID:      proto.String(t.ContextInfo.GetStanzaID()),
-				Participant:   proto.String(t.ContextInfo.GetParticipant()),
-				QuotedMessage: &waE2E.Message{Conversation: proto.String("")},
-			}
-		}
+		setContextInfo(msg, contextInfo)
+		applyDisappearingTimer(userid, recipient, msg)
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
 			return
 		}
+		storeSentMessage(userid, recipient, resp, msg)
 
 		// log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
@@ -1459,8 +1532,231 @@ func (s *server) SendLocation() http.HandlerFunc {
 	}
 }
 
-// Sends Buttons (not implemented, does not work)
+// Starts sharing a live location
+func (s *server) SendLiveLocation() http.HandlerFunc {
+
+	type liveLocationStruct struct {
+		Phone            string
+		Id               string
+		Latitude         float64
+		Longitude        float64
+		AccuracyInMeters uint32
+		Caption          string
+		Duration         int
+		ContextInfo      waE2E.ContextInfo
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		msgid := ""
+		var resp whatsmeow.SendResponse
+
+		decoder := json.NewDecoder(r.Body)
+		var t liveLocationStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+		if t.Phone == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+			return
+		}
+		if t.Latitude == 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing latitude in payload"))
+			return
+		}
+		if t.Longitude == 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing longitude in payload"))
+			return
+		}
+
+		duration := time.Duration(t.Duration) * time.Second
+		if duration == 0 {
+			duration = 15 * time.Minute
+		}
+		if duration < 0 || duration > maxLiveLocationDuration {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("duration must be between 1 second and 8 hours"))
+			return
+		}
+
+		contextInfo, err := buildContextInfo(userid, &t.ContextInfo)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		recipient, err := validateMessageFields(t.Phone, contextInfo.GetStanzaID(), contextInfo.GetParticipant())
+		if err != nil {
+			log.Error().Msg(fmt.Sprintf("%s", err))
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if t.Id == "" {
+			msgid = whatsmeow.GenerateMessageID()
+		} else {
+			msgid = t.Id
+		}
+
+		location := &liveLocation{Recipient: recipient, Caption: t.Caption, ContextInfo: contextInfo, Started: time.Now(), Duration: duration}
+		msg, err := location.nextMessage(t.Latitude, t.Longitude, t.AccuracyInMeters, 0, 0)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		resp, err = sendLiveLocation(userid, msgid, location, msg)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			return
+		}
+		storeLiveLocation(userid, msgid, location)
+
+		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid, "ExpiresAt": location.Started.Add(duration)}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			errCount := s.service.SetCountMsg(uint(userid), "location")
+			if errCount != nil {
+				log.Error().Err(errCount).Msg("Failed to increment location message count")
+			}
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		// return
+	}
+}
+
+// Sends new coordinates for a live location being shared
+func (s *server) UpdateLiveLocation() http.HandlerFunc {
+
+	type updateStruct struct {
+		Id               string
+		Latitude         float64
+		Longitude        float64
+		AccuracyInMeters uint32
+		SpeedInMps       float32
+		Heading          uint32
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t updateStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+		if t.Id == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing id in payload"))
+			return
+		}
+		if t.Latitude == 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing latitude in payload"))
+			return
+		}
+		if t.Longitude == 0 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing longitude in payload"))
+			return
+		}
+
+		location, found := getLiveLocation(userid, t.Id)
+		if !found {
+			s.Respond(w, r, http.StatusNotFound, errors.New("live location not found, it was stopped or its duration is over"))
+			return
+		}
+
+		msg, err := location.nextMessage(t.Latitude, t.Longitude, t.AccuracyInMeters, t.SpeedInMps, t.Heading)
+		if err != nil {
+			s.Respond(w, r, http.StatusNotFound, errors.New("live location not found, it was stopped or its duration is over"))
+			return
+		}
+		resp, err := sendLiveLocation(userid, t.Id, location, msg)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Updated", "Timestamp": resp.Timestamp, "Id": t.Id, "SequenceNumber": msg.GetLiveLocationMessage().GetSequenceNumber()}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		// return
+	}
+}
+
+// Stops sharing a live location
+func (s *server) StopLiveLocation() http.HandlerFunc {
+
+	type stopStruct struct {
+		Id string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t stopStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+		if t.Id == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing id in payload"))
+			return
+		}
+
+		location, found := getLiveLocation(userid, t.Id)
+		if !found {
+			s.Respond(w, r, http.StatusNotFound, errors.New("live location not found, it was stopped or its duration is over"))
+			return
+		}
+		resp, err := stopLiveLocation(userid, t.Id, location)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Stopped", "Timestamp": resp.Timestamp, "Id": t.Id}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		// return
+	}
+}
 
+// Sends reply buttons as a native flow interactive message
 func (s *server) SendButtons() http.HandlerFunc {
 
 	type buttonStruct struct {
@@ -1468,10 +1764,12 @@ func (s *server) SendButtons() http.HandlerFunc {
 		ButtonText string
 	}
 	type textStruct struct {
-		Phone   string
-		Title   string
-		Buttons []buttonStruct
-		Id      string
+		Phone    string
+		Title    string
+		Footer   string
+		Buttons  []buttonStruct
+		Fallback bool
+		Id       string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1526,28 +1824,23 @@ func (s *server) SendButtons() http.HandlerFunc {
 			msgid = t.Id
 		}
 
-		var buttons []*waE2E.ButtonsMessage_Button
-
-		for _, item := range t.Buttons {
-			buttons = append(buttons, &waE2E.ButtonsMessage_Button{
-				ButtonID:       proto.String(item.ButtonId),
-				ButtonText:     &waE2E.ButtonsMessage_Button_ButtonText{DisplayText: proto.String(item.ButtonText)},
-				Type:           waE2E.ButtonsMessage_Button_RESPONSE.Enum(),
-				NativeFlowInfo: &waE2E.ButtonsMessage_Button_NativeFlowInfo{},
-			})
+		var buttons []*waE2E.InteractiveMessage_NativeFlowMessage_NativeFlowButton
+		var options []string
+		for i, item := range t.Buttons {
+			buttonid := item.ButtonId
+			if buttonid == "" {
+				buttonid = strconv.Itoa(i + 1)
+			}
+			buttons = append(buttons, nativeFlowButton("quick_reply", map[string]interface{}{"display_text": item.ButtonText, "id": buttonid}))
+			options = append(options, fmt.Sprintf("%d. %s", i+1, item.ButtonText))
 		}
 
-		msg2 := &waE2E.ButtonsMessage{
-			ContentText: proto.String(t.Title),
-			HeaderType:  waE2E.ButtonsMessage_EMPTY.Enum(),
-			Buttons:     buttons,
+		body := t.Title
+		if t.Fallback {
+			body = interactiveFallback(body, options)
 		}
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, &waE2E.Message{ViewOnceMessage: &waE2E.FutureProofMessage{
-			Message: &waE2E.Message{
-				ButtonsMessage: msg2,
-			},
-		}})
+		resp, err = sendInteractive(userid, recipient, msgid, buildInteractiveMessage("", body, t.Footer, buttons))
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
 			return
@@ -1561,12 +1854,12 @@ func (s *server) SendButtons() http.HandlerFunc {
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
+
 		// return
 	}
 }
 
-// SendList
-// https://github.com/tulir/whatsmeow/issues/305
+// Sends a list as a native flow single select message
 func (s *server) SendList() http.HandlerFunc {
 
 	type rowsStruct struct {
@@ -1587,6 +1880,7 @@ func (s *server) SendList() http.HandlerFunc {
 		ButtonText  string
 		FooterText  string
 		Sections    []sectionsStruct
+		Fallback    bool
 		Id          string
 	}
 
@@ -1606,10 +1900,7 @@ func (s *server) SendList() http.HandlerFunc {
 		decoder := json.NewDecoder(r.Body)
 		var t listStruct
 		err := decoder.Decode(&t)
-		marshal, _ := json.Marshal(t)
-		fmt.Println(string(marshal))
 		if err != nil {
-			fmt.Println(err)
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
 			return
 		}
@@ -1650,45 +1941,36 @@ func (s *server) SendList() http.HandlerFunc {
 			msgid = t.Id
 		}
 
-		var sections []*waE2E.ListMessage_Section
-
+		var sections []map[string]interface{}
+		var options []string
+		id := 1
 		for _, item := range t.Sections {
-			var rows []*waE2E.ListMessage_Row
-			id := 1
+			var rows []map[string]interface{}
+			if item.Title != "" {
+				options = append(options, item.Title)
+			}
 			for _, row := range item.Rows {
-				var idtext string
-				if row.RowId == "" {
-					idtext = strconv.Itoa(id)
-				} else {
-					idtext = row.RowId
+				rowid := row.RowId
+				if rowid == "" {
+					rowid = strconv.Itoa(id)
 				}
-				rows = append(rows, &waE2E.ListMessage_Row{
-					RowID:       proto.String(idtext),
-					Title:       proto.String(row.Title),
-					Description: proto.String(row.Description),
-				})
+				rows = append(rows, map[string]interface{}{"id": rowid, "title": row.Title, "description": row.Description})
+				options = append(options, fmt.Sprintf("%d. %s", id, row.Title))
+				id++
 			}
+			sections = append(sections, map[string]interface{}{"title": item.Title, "rows": rows})
+		}
 
-			sections = append(sections, &waE2E.ListMessage_Section{
-				Title: proto.String(item.Title),
-				Rows:  rows,
-			})
+		buttons := []*waE2E.InteractiveMessage_NativeFlowMessage_NativeFlowButton{
+			nativeFlowButton("single_select", map[string]interface{}{"title": t.ButtonText, "sections": sections}),
 		}
-		msg1 := &waE2E.ListMessage{
-			Title:       proto.String(t.Title),
-			Description: proto.String(t.Description),
-			ButtonText:  proto.String(t.ButtonText),
-			ListType:    waE2E.ListMessage_SINGLE_SELECT.Enum(),
-			Sections:    sections,
-			FooterText:  proto.String(t.FooterText),
+
+		body := t.Description
+		if t.Fallback {
+			body = interactiveFallback(body, options)
 		}
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, &waE2E.Message{
-			ViewOnceMessage: &waE2E.FutureProofMessage{
-				Message: &waE2E.Message{
-					ListMessage: msg1,
-				},
-			}})
+		resp, err = sendInteractive(userid, recipient, msgid, buildInteractiveMessage(t.Title, body, t.FooterText, buttons))
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
 			return
@@ -1702,6 +1984,7 @@ func (s *server) SendList() http.HandlerFunc {
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
+
 		// return
 	}
 }
@@ -1714,6 +1997,8 @@ func (s *server) SendMessage() http.HandlerFunc {
 		Body        string
 		Id          string
 		ContextInfo waE2E.ContextInfo
+		Mentions    []string
+		MentionAll  bool
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1747,13 +2032,24 @@ func (s *server) SendMessage() http.HandlerFunc {
 			return
 		}
 
-		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.GetStanzaID(), t.ContextInfo.GetParticipant())
+		contextInfo, err := buildContextInfo(userid, &t.ContextInfo)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		recipient, err := validateMessageFields(t.Phone, contextInfo.GetStanzaID(), contextInfo.GetParticipant())
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
 			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
+		contextInfo, status, err := addMentions(userid, contextInfo, recipient, t.Body, t.Mentions, t.MentionAll)
+		if err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
+
 		if t.Id == "" {
 			msgid = whatsmeow.GenerateMessageID()
 		} else {
@@ -1768,19 +2064,15 @@ func (s *server) SendMessage() http.HandlerFunc {
 			},
 		}
 
-		if t.ContextInfo.GetStanzaID() != "" {
-			msg.ExtendedTextMessage.ContextInfo = &waE2E.ContextInfo{
-				StanzaID:      proto.String(t.ContextInfo.GetStanzaID()),
-				Participant:   proto.String(t.ContextInfo.GetParticipant()),
-				QuotedMessage: &waE2E.Message{Conversation: proto.String("")},
-			}
-		}
+		setContextInfo(msg, contextInfo)
+		applyDisappearingTimer(userid, recipient, msg)
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
 			return
 		}
+		storeSentMessage(userid, recipient, resp, msg)
 
 		// log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
@@ -1799,24 +2091,16 @@ func (s *server) SendMessage() http.HandlerFunc {
 	}
 }
 
-/*
-// Sends a Template message
-func (s *server) SendTemplate() http.HandlerFunc {
-
-	type buttonStruct struct {
-		DisplayText string
-		Id          string
-		Url         string
-		PhoneNumber string
-		Type        string
-	}
+// Sends a poll
+func (s *server) SendPoll() http.HandlerFunc {
 
-	type templateStruct struct {
-		Phone   string
-		Content string
-		Footer  string
-		Id      string
-		Buttons []buttonStruct
+	type pollStruct struct {
+		Phone           string
+		Question        string
+		Options         []string
+		SelectableCount int
+		Id              string
+		ContextInfo     waE2E.ContextInfo
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -1831,10 +2115,9 @@ func (s *server) SendTemplate() http.HandlerFunc {
 
 		msgid := ""
 		var resp whatsmeow.SendResponse
-//var ts time.Time
 
 		decoder := json.NewDecoder(r.Body)
-		var t templateStruct
+		var t pollStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
@@ -1846,24 +2129,40 @@ func (s *server) SendTemplate() http.HandlerFunc {
 			return
 		}
 
-		if t.Content == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Content in Payload"))
+		if t.Question == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing question in payload"))
 			return
 		}
 
-		if t.Footer == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Footer in Payload"))
+		if len(t.Options) < 2 || len(t.Options) > 12 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("polls must have between 2 and 12 options"))
 			return
 		}
 
-		if len(t.Buttons) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Missing Buttons in Payload"))
+		// Votes only carry the hashes of the selected options, so options must be unique to tell them apart
+		seen := make(map[string]bool)
+		for _, option := range t.Options {
+			if option == "" || seen[option] {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("poll options must be unique and not empty"))
+				return
+			}
+			seen[option] = true
+		}
+
+		if t.SelectableCount < 0 || t.SelectableCount > len(t.Options) {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("selectable count must be between 0 (any number) and the number of options"))
 			return
 		}
 
-		recipient, ok := parseJID(t.Phone)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+		contextInfo, err := buildContextInfo(userid, &t.ContextInfo)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+		recipient, err := validateMessageFields(t.Phone, contextInfo.GetStanzaID(), contextInfo.GetParticipant())
+		if err != nil {
+			log.Error().Msg(fmt.Sprintf("%s", err))
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
@@ -1873,80 +2172,17 @@ func (s *server) SendTemplate() http.HandlerFunc {
 			msgid = t.Id
 		}
 
-		var buttons []*waE2E.HydratedTemplateButton
-
-		id := 1
-		for _, item := range t.Buttons {
-			switch item.Type {
-			case "quickreply":
-				var idtext string
-				text := item.DisplayText
-				if item.Id == "" {
-					idtext = strconv.Itoa(id)
-				} else {
-					idtext = item.Id
-				}
-				buttons = append(buttons, &waE2E.HydratedTemplateButton{
-					HydratedButton: &waE2E.HydratedTemplateButton_QuickReplyButton{
-						QuickReplyButton: &waE2E.HydratedQuickReplyButton{
-							DisplayText: &text,
-							Id:          proto.String(idtext),
-						},
-					},
-				})
-			case "url":
-				text := item.DisplayText
-				url := item.Url
-				buttons = append(buttons, &waE2E.HydratedTemplateButton{
-					HydratedButton: &waE2E.HydratedTemplateButton_UrlButton{
-						UrlButton: &waE2E.HydratedURLButton{
-							DisplayText: &text,
-							URL:         &url,
-						},
-					},
-				})
-			case "call":
-				text := item.DisplayText
-				phonenumber := item.PhoneNumber
-				buttons = append(buttons, &waE2E.HydratedTemplateButton{
-					HydratedButton: &waE2E.HydratedTemplateButton_CallButton{
-						CallButton: &waE2E.HydratedCallButton{
-							DisplayText: &text,
-							PhoneNumber: &phonenumber,
-						},
-					},
-				})
-			default:
-				text := item.DisplayText
-				buttons = append(buttons, &waE2E.HydratedTemplateButton{
-					HydratedButton: &waE2E.HydratedTemplateButton_QuickReplyButton{
-						QuickReplyButton: &waE2E.HydratedQuickReplyButton{
-							DisplayText: &text,
-							Id:          proto.String(string(id)),
-						},
-					},
-				})
-			}
-			id++
-		}
-
-		msg := &waE2E.Message{TemplateMessage: &waE2E.TemplateMessage{
-			HydratedTemplate: &waE2E.HydratedFourRowTemplate{
-				HydratedContentText: proto.String(t.Content),
-				HydratedFooterText:  proto.String(t.Footer),
-				HydratedButtons:     buttons,
-				TemplateId:          proto.String("1"),
-			},
-		},
-		}
+		msg := clientPointer[userid].BuildPollCreation(t.Question, t.Options, t.SelectableCount)
+		setContextInfo(msg, contextInfo)
+		applyDisappearingTimer(userid, recipient, msg)
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(),recipient, msg)
+		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
 			return
 		}
+		storeSentMessage(userid, recipient, resp, msg)
 
-		// log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
@@ -1954,11 +2190,148 @@ func (s *server) SendTemplate() http.HandlerFunc {
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
-		return
+
+		// return
 	}
 }
-*/
-// checks if users/phones are on Whatsapp
+
+// Sends a Template message, with quick reply, url, call and copy buttons
+func (s *server) SendTemplate() http.HandlerFunc {
+
+	type buttonStruct struct {
+		DisplayText string
+		Id          string
+		Url         string
+		PhoneNumber string
+		CopyCode    string
+		Type        string
+	}
+
+	type templateStruct struct {
+		Phone    string
+		Title    string
+		Content  string
+		Footer   string
+		Id       string
+		Buttons  []buttonStruct
+		Fallback bool
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		msgid := ""
+		var resp whatsmeow.SendResponse
+
+		decoder := json.NewDecoder(r.Body)
+		var t templateStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.Phone == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+			return
+		}
+
+		if t.Content == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing content in payload"))
+			return
+		}
+
+		if len(t.Buttons) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing buttons in payload"))
+			return
+		}
+
+		recipient, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+			return
+		}
+
+		if t.Id == "" {
+			msgid = whatsmeow.GenerateMessageID()
+		} else {
+			msgid = t.Id
+		}
+
+		var buttons []*waE2E.InteractiveMessage_NativeFlowMessage_NativeFlowButton
+		var options []string
+
+		for i, item := range t.Buttons {
+			if item.DisplayText == "" {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("missing displaytext in button"))
+				return
+			}
+			switch item.Type {
+			case "quickreply", "":
+				buttonid := item.Id
+				if buttonid == "" {
+					buttonid = strconv.Itoa(i + 1)
+				}
+				buttons = append(buttons, nativeFlowButton("quick_reply", map[string]interface{}{"display_text": item.DisplayText, "id": buttonid}))
+				options = append(options, fmt.Sprintf("%d. %s", i+1, item.DisplayText))
+			case "url":
+				if item.Url == "" {
+					s.Respond(w, r, http.StatusBadRequest, errors.New("missing url in url button"))
+					return
+				}
+				buttons = append(buttons, nativeFlowButton("cta_url", map[string]interface{}{"display_text": item.DisplayText, "url": item.Url, "merchant_url": item.Url}))
+				options = append(options, fmt.Sprintf("%s: %s", item.DisplayText, item.Url))
+			case "call":
+				if item.PhoneNumber == "" {
+					s.Respond(w, r, http.StatusBadRequest, errors.New("missing phonenumber in call button"))
+					return
+				}
+				buttons = append(buttons, nativeFlowButton("cta_call", map[string]interface{}{"display_text": item.DisplayText, "phone_number": item.PhoneNumber}))
+				options = append(options, fmt.Sprintf("%s: %s", item.DisplayText, item.PhoneNumber))
+			case "copy":
+				if item.CopyCode == "" {
+					s.Respond(w, r, http.StatusBadRequest, errors.New("missing copycode in copy button"))
+					return
+				}
+				buttons = append(buttons, nativeFlowButton("cta_copy", map[string]interface{}{"display_text": item.DisplayText, "copy_code": item.CopyCode}))
+				options = append(options, fmt.Sprintf("%s: %s", item.DisplayText, item.CopyCode))
+			default:
+				s.Respond(w, r, http.StatusBadRequest, errors.New("button type must be one of quickreply, url, call or copy"))
+				return
+			}
+		}
+
+		body := t.Content
+		if t.Fallback {
+			body = interactiveFallback(body, options)
+		}
+
+		resp, err = sendInteractive(userid, recipient, msgid, buildInteractiveMessage(t.Title, body, t.Footer, buttons))
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			return
+		}
+
+		// log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
+		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// checks if users/phones are on Whatsapp
 func (s *server) CheckUser() http.HandlerFunc {
 
 	type checkUserStruct struct {
@@ -2192,13 +2565,11 @@ func (s *server) GetContacts() http.HandlerFunc {
 	}
 }
 
-// Sets Chat Presence (typing/paused/recording audio)
-func (s *server) ChatPresence() http.HandlerFunc {
+// Sets the push name (display name) of the account
+func (s *server) SetPushName() http.HandlerFunc {
 
-	type chatPresenceStruct struct {
-		Phone string
-		State string
-		Media types.ChatPresenceMedia
+	type pushNameStruct struct {
+		Name string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -2212,57 +2583,42 @@ func (s *server) ChatPresence() http.HandlerFunc {
 		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t chatPresenceStruct
+		var t pushNameStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		if len(t.Phone) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
-			return
-		}
-
-		if len(t.State) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing state in payload"))
-			return
-		}
-
-		jid, ok := parseJID(t.Phone)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+		name := strings.TrimSpace(t.Name)
+		if name == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing name in payload"))
 			return
 		}
 
-		err = clientPointer[userid].SendChatPresence(jid, types.ChatPresence(t.State), types.ChatPresenceMedia(t.Media))
+		// Once the change is synced back, the PushNameSetting event sends the presence again with the new name
+		err = clientPointer[userid].SendAppState(appstate.BuildSettingPushName(name))
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("failure sending chat presence to Whatsapp servers"))
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to set push name: %v", err)))
 			return
 		}
 
-		response := map[string]interface{}{"Details": "Chat presence set successfuly"}
+		response := map[string]interface{}{"Details": "Push name set", "Name": name}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
-		// return
+		return
 	}
 }
 
-// Downloads Image and returns base64 representation
-func (s *server) DownloadImage() http.HandlerFunc {
+// Sets the about text of the account
+func (s *server) SetAbout() http.HandlerFunc {
 
-	type downloadImageStruct struct {
-		Url           string
-		DirectPath    string
-		MediaKey      []byte
-		Mimetype      string
-		FileEncSHA256 []byte
-		FileSHA256    []byte
-		FileLength    uint64
+	type aboutStruct struct {
+		About string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -2270,79 +2626,48 @@ func (s *server) DownloadImage() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		userid, _ := strconv.Atoi(txtid)
 
-		mimetype := ""
-		var imgdata []byte
-
 		if clientPointer[userid] == nil {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
 			return
 		}
 
-		// check/creates user directory for files
-		userDirectory := fmt.Sprintf("%s/files/user_%s", s.exPath, txtid)
-		_, err := os.Stat(userDirectory)
-		if os.IsNotExist(err) {
-			errDir := os.MkdirAll(userDirectory, 0751)
-			if errDir != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not create user directory (%s)", userDirectory)))
-				return
-			}
-		}
-
 		decoder := json.NewDecoder(r.Body)
-		var t downloadImageStruct
-		err = decoder.Decode(&t)
+		var t aboutStruct
+		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		msg := &waE2E.Message{ImageMessage: &waE2E.ImageMessage{
-			URL:           proto.String(t.Url),
-			DirectPath:    proto.String(t.DirectPath),
-			MediaKey:      t.MediaKey,
-			Mimetype:      proto.String(t.Mimetype),
-			FileEncSHA256: t.FileEncSHA256,
-			FileSHA256:    t.FileSHA256,
-			FileLength:    &t.FileLength,
-		}}
-
-		img := msg.GetImageMessage()
+		if t.About == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing about in payload"))
+			return
+		}
 
-		if img != nil {
-			imgdata, err = clientPointer[userid].Download(img)
-			if err != nil {
-				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to download image")
-				msg := fmt.Sprintf("Failed to download image %v", err)
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
-				return
-			}
-			mimetype = img.GetMimetype()
+		err = clientPointer[userid].SetStatusMessage(t.About)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to set about: %v", err)))
+			return
 		}
 
-		dataURL := dataurl.New(imgdata, mimetype)
-		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
+		response := map[string]interface{}{"Details": "About set"}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
-		// return
+		return
 	}
 }
 
-// Downloads Document and returns base64 representation
-func (s *server) DownloadDocument() http.HandlerFunc {
+// Sets or removes the profile picture of the account
+func (s *server) SetProfilePhoto() http.HandlerFunc {
 
-	type downloadDocumentStruct struct {
-		Url           string
-		DirectPath    string
-		MediaKey      []byte
-		Mimetype      string
-		FileEncSHA256 []byte
-		FileSHA256    []byte
-		FileLength    uint64
+	type profilePhotoStruct struct {
+		Image  string
+		Url    string
+		Remove bool
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -2350,79 +2675,58 @@ func (s *server) DownloadDocument() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		userid, _ := strconv.Atoi(txtid)
 
-		mimetype := ""
-		var docdata []byte
-
 		if clientPointer[userid] == nil {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
 			return
 		}
 
-		// check/creates user directory for files
-		userDirectory := fmt.Sprintf("%s/files/user_%s", s.exPath, txtid)
-		_, err := os.Stat(userDirectory)
-		if os.IsNotExist(err) {
-			errDir := os.MkdirAll(userDirectory, 0751)
-			if errDir != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not create user directory (%s)", userDirectory)))
-				return
-			}
-		}
-
-		decoder := json.NewDecoder(r.Body)
-		var t downloadDocumentStruct
-		err = decoder.Decode(&t)
+		var t profilePhotoStruct
+		upload, err := decodeMediaPayload(w, r, &t, "Image")
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.Respond(w, r, http.StatusBadRequest, err)
 			return
 		}
 
-		msg := &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{
-			URL:           proto.String(t.Url),
-			DirectPath:    proto.String(t.DirectPath),
-			MediaKey:      t.MediaKey,
-			Mimetype:      proto.String(t.Mimetype),
-			FileEncSHA256: t.FileEncSHA256,
-			FileSHA256:    t.FileSHA256,
-			FileLength:    &t.FileLength,
-		}}
-
-		doc := msg.GetDocumentMessage()
-
-		if doc != nil {
-			docdata, err = clientPointer[userid].Download(doc)
+		// A nil picture removes the current one
+		var picture []byte
+		if !t.Remove {
+			media, err := loadMedia(upload, t.Image, t.Url, "image")
 			if err != nil {
-				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to download document")
-				msg := fmt.Sprintf("Failed to download document %v", err)
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
+				s.Respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+			picture, err = profilePicture(media.Data)
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, err)
 				return
 			}
-			mimetype = doc.GetMimetype()
 		}
 
-		dataURL := dataurl.New(docdata, mimetype)
-		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
+		pictureId, err := clientPointer[userid].SetGroupPhoto(types.EmptyJID, picture)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to set profile photo: %v", err)))
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Profile photo set", "PictureID": pictureId}
+		if t.Remove {
+			response = map[string]interface{}{"Details": "Profile photo removed"}
+		}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
-		// return
+		return
 	}
 }
 
-// Downloads Video and returns base64 representation
-func (s *server) DownloadVideo() http.HandlerFunc {
+// Gets the business profile of a user
+func (s *server) GetBusinessProfile() http.HandlerFunc {
 
-	type downloadVideoStruct struct {
-		Url           string
-		DirectPath    string
-		MediaKey      []byte
-		Mimetype      string
-		FileEncSHA256 []byte
-		FileSHA256    []byte
-		FileLength    uint64
+	type businessProfileStruct struct {
+		Phone string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -2430,156 +2734,77 @@ func (s *server) DownloadVideo() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		userid, _ := strconv.Atoi(txtid)
 
-		mimetype := ""
-		var docdata []byte
-
 		if clientPointer[userid] == nil {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
 			return
 		}
 
-		// check/creates user directory for files
-		userDirectory := fmt.Sprintf("%s/files/user_%s", s.exPath, txtid)
-		_, err := os.Stat(userDirectory)
-		if os.IsNotExist(err) {
-			errDir := os.MkdirAll(userDirectory, 0751)
-			if errDir != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not create user directory (%s)", userDirectory)))
-				return
-			}
-		}
-
 		decoder := json.NewDecoder(r.Body)
-		var t downloadVideoStruct
-		err = decoder.Decode(&t)
+		var t businessProfileStruct
+		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		msg := &waE2E.Message{VideoMessage: &waE2E.VideoMessage{
-			URL:           proto.String(t.Url),
-			DirectPath:    proto.String(t.DirectPath),
-			MediaKey:      t.MediaKey,
-			Mimetype:      proto.String(t.Mimetype),
-			FileEncSHA256: t.FileEncSHA256,
-			FileSHA256:    t.FileSHA256,
-			FileLength:    &t.FileLength,
-		}}
+		if len(t.Phone) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+			return
+		}
 
-		doc := msg.GetVideoMessage()
+		jid, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+			return
+		}
 
-		if doc != nil {
-			docdata, err = clientPointer[userid].Download(doc)
-			if err != nil {
-				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to download video")
-				msg := fmt.Sprintf("Failed to download video %v", err)
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
-				return
-			}
-			mimetype = doc.GetMimetype()
+		profile, err := clientPointer[userid].GetBusinessProfile(jid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to get business profile: %v", err)))
+			return
 		}
 
-		dataURL := dataurl.New(docdata, mimetype)
-		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
-		responseJson, err := json.Marshal(response)
+		responseJson, err := json.Marshal(profile)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
-		// return
+		return
 	}
 }
 
-// Downloads Audio and returns base64 representation
-func (s *server) DownloadAudio() http.HandlerFunc {
-
-	type downloadAudioStruct struct {
-		Url           string
-		DirectPath    string
-		MediaKey      []byte
-		Mimetype      string
-		FileEncSHA256 []byte
-		FileSHA256    []byte
-		FileLength    uint64
-	}
+// Gets the privacy settings of the account
+func (s *server) GetPrivacySettings() http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		userid, _ := strconv.Atoi(txtid)
 
-		mimetype := ""
-		var docdata []byte
-
 		if clientPointer[userid] == nil {
 			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
 			return
 		}
 
-		// check/creates user directory for files
-		userDirectory := fmt.Sprintf("%s/files/user_%s", s.exPath, txtid)
-		_, err := os.Stat(userDirectory)
-		if os.IsNotExist(err) {
-			errDir := os.MkdirAll(userDirectory, 0751)
-			if errDir != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not create user directory (%s)", userDirectory)))
-				return
-			}
-		}
-
-		decoder := json.NewDecoder(r.Body)
-		var t downloadAudioStruct
-		err = decoder.Decode(&t)
+		settings, err := clientPointer[userid].TryFetchPrivacySettings(r.URL.Query().Get("refresh") == "true")
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to get privacy settings: %v", err)))
 			return
 		}
 
-		msg := &waE2E.Message{AudioMessage: &waE2E.AudioMessage{
-			URL:           proto.String(t.Url),
-			DirectPath:    proto.String(t.DirectPath),
-			MediaKey:      t.MediaKey,
-			Mimetype:      proto.String(t.Mimetype),
-			FileEncSHA256: t.FileEncSHA256,
-			FileSHA256:    t.FileSHA256,
-			FileLength:    &t.FileLength,
-		}}
-
-		doc := msg.GetAudioMessage()
-
-		if doc != nil {
-			docdata, err = clientPointer[userid].Download(doc)
-			if err != nil {
-				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to download audio")
-				msg := fmt.Sprintf("Failed to download audio %v", err)
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
-				return
-			}
-			mimetype = doc.GetMimetype()
-		}
-
-		dataURL := dataurl.New(docdata, mimetype)
-		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
-		responseJson, err := json.Marshal(response)
+		responseJson, err := json.Marshal(settings)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
-		// return
+		return
 	}
 }
 
-// React
-func (s *server) React() http.HandlerFunc {
-
-	type textStruct struct {
-		Phone string
-		Body  string
-		Id    string
-	}
+// Changes one or more privacy settings of the account
+func (s *server) SetPrivacySettings() http.HandlerFunc {
 
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -2591,73 +2816,2702 @@ func (s *server) React() http.HandlerFunc {
 			return
 		}
 
+		decoder := json.NewDecoder(r.Body)
+		var t map[string]string
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		options, values, err := parsePrivacyChanges(t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		var settings types.PrivacySettings
+		for i, option := range options {
+			settings, err = clientPointer[userid].SetPrivacySetting(option.Type, values[i])
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to set %s privacy: %v", option.Name, err)))
+				return
+			}
+		}
+
+		responseJson, err := json.Marshal(settings)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Gets the list of blocked contacts
+func (s *server) GetBlocklist() http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		blocklist, err := clientPointer[userid].GetBlocklist()
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to get blocklist: %v", err)))
+			return
+		}
+
+		responseJson, err := json.Marshal(blocklistSummary(blocklist))
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Blocks or unblocks a contact
+func (s *server) UpdateBlocklist(block bool) http.HandlerFunc {
+
+	type blocklistStruct struct {
+		Phone string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t blocklistStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if len(t.Phone) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+			return
+		}
+
+		jid, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+			return
+		}
+
+		action := events.BlocklistChangeActionUnblock
+		if block {
+			action = events.BlocklistChangeActionBlock
+		}
+		blocklist, err := clientPointer[userid].UpdateBlocklist(jid, action)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to %s contact: %v", action, err)))
+			return
+		}
+
+		response := blocklistSummary(blocklist)
+		response["Details"] = "Contact unblocked"
+		if block {
+			response["Details"] = "Contact blocked"
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Gets the presence policy of the account and the presence it currently asks for
+func (s *server) GetPresence() http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		state := getPresenceState(userid, s.service)
+		state.Lock()
+		policy := state.policy
+		state.Unlock()
+
+		response := map[string]interface{}{"Policy": policy, "Presence": policy.presenceAt(time.Now())}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Sets the presence policy of the account: always available, always unavailable or available during business hours
+func (s *server) SetPresence() http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t presencePolicy
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		err = t.validate()
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		presence, err := setPresencePolicy(clientPointer[userid], userid, s.service, t)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to set presence: %v", err)))
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Presence policy set", "Policy": t, "Presence": presence}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Subscribes to the presence of a contact, which is then delivered as Presence events
+func (s *server) SubscribePresence() http.HandlerFunc {
+
+	type subscribePresenceStruct struct {
+		Phone string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t subscribePresenceStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if len(t.Phone) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+			return
+		}
+
+		jid, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+			return
+		}
+
+		err = clientPointer[userid].SubscribePresence(jid)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to subscribe to presence: %v", err)))
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Subscribed to presence", "JID": jid.String()}
+		// WhatsApp only delivers the presence of contacts to accounts that are available themselves
+		state := getPresenceState(userid, s.service)
+		state.Lock()
+		if state.policy.presenceAt(time.Now()) != types.PresenceAvailable {
+			response["Warning"] = "presence updates are only delivered while the account is available"
+		}
+		state.Unlock()
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Sets Chat Presence (typing/paused/recording audio)
+func (s *server) ChatPresence() http.HandlerFunc {
+
+	type chatPresenceStruct struct {
+		Phone string
+		State string
+		Media types.ChatPresenceMedia
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t chatPresenceStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if len(t.Phone) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+			return
+		}
+
+		if len(t.State) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing state in payload"))
+			return
+		}
+
+		jid, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+			return
+		}
+
+		err = clientPointer[userid].SendChatPresence(jid, types.ChatPresence(t.State), types.ChatPresenceMedia(t.Media))
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("failure sending chat presence to Whatsapp servers"))
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Chat presence set successfuly"}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		// return
+	}
+}
+
+// Downloads media from a stored message id or from its raw keys and returns it as a data URL or binary stream.
+// Legacy per-type routes pass the media type, which selects the decryption keys, instead of inferring it
+func (s *server) Download(forcedType string) http.HandlerFunc {
+
+	type downloadStruct struct {
+		Id            string
+		Type          string
+		Url           string
+		DirectPath    string
+		MediaKey      []byte
+		Mimetype      string
+		FileEncSHA256 []byte
+		FileSHA256    []byte
+		FileLength    uint64
+		Binary        bool
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t downloadStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		var stored *events.Message
+		var media whatsmeow.DownloadableMessage
+
+		if t.Id != "" {
+			var found bool
+			stored, found = getStoredMessage(userid, t.Id)
+			if !found {
+				s.Respond(w, r, http.StatusNotFound, errors.New("message not found"))
+				return
+			}
+			media = getDownloadable(stored.Message)
+			if media == nil {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("message has no downloadable media"))
+				return
+			}
+		} else {
+			if t.DirectPath == "" && t.Url == "" {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("missing id or media keys in payload"))
+				return
+			}
+			if len(t.MediaKey) == 0 {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("missing mediakey in payload"))
+				return
+			}
+
+			mediatype := strings.ToLower(t.Type)
+			if mediatype == "" {
+				mediatype = forcedType
+			}
+			if mediatype == "" {
+				switch {
+				case t.Mimetype == "image/webp":
+					mediatype = "sticker"
+				case strings.HasPrefix(t.Mimetype, "image/"):
+					mediatype = "image"
+				case strings.HasPrefix(t.Mimetype, "video/"):
+					mediatype = "video"
+				case strings.HasPrefix(t.Mimetype, "audio/"):
+					mediatype = "audio"
+				default:
+					mediatype = "document"
+				}
+			}
+
+			switch mediatype {
+			case "image":
+				media = &waE2E.ImageMessage{URL: proto.String(t.Url), DirectPath: proto.String(t.DirectPath), MediaKey: t.MediaKey, Mimetype: proto.String(t.Mimetype), FileEncSHA256: t.FileEncSHA256, FileSHA256: t.FileSHA256, FileLength: proto.Uint64(t.FileLength)}
+			case "video":
+				media = &waE2E.VideoMessage{URL: proto.String(t.Url), DirectPath: proto.String(t.DirectPath), MediaKey: t.MediaKey, Mimetype: proto.String(t.Mimetype), FileEncSHA256: t.FileEncSHA256, FileSHA256: t.FileSHA256, FileLength: proto.Uint64(t.FileLength)}
+			case "audio":
+				media = &waE2E.AudioMessage{URL: proto.String(t.Url), DirectPath: proto.String(t.DirectPath), MediaKey: t.MediaKey, Mimetype: proto.String(t.Mimetype), FileEncSHA256: t.FileEncSHA256, FileSHA256: t.FileSHA256, FileLength: proto.Uint64(t.FileLength)}
+			case "document":
+				media = &waE2E.DocumentMessage{URL: proto.String(t.Url), DirectPath: proto.String(t.DirectPath), MediaKey: t.MediaKey, Mimetype: proto.String(t.Mimetype), FileEncSHA256: t.FileEncSHA256, FileSHA256: t.FileSHA256, FileLength: proto.Uint64(t.FileLength)}
+			case "sticker":
+				media = &waE2E.StickerMessage{URL: proto.String(t.Url), DirectPath: proto.String(t.DirectPath), MediaKey: t.MediaKey, Mimetype: proto.String(t.Mimetype), FileEncSHA256: t.FileEncSHA256, FileSHA256: t.FileSHA256, FileLength: proto.Uint64(t.FileLength)}
+			default:
+				s.Respond(w, r, http.StatusBadRequest, errors.New("type should be one of image, video, audio, document or sticker"))
+				return
+			}
+		}
+
+		data, err := clientPointer[userid].Download(media)
+		if err != nil && stored != nil && (errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith404) || errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith410)) {
+			log.Warn().Str("id", t.Id).Msg("Media expired on server, requesting re-upload")
+			data, err = retryMediaDownload(userid, stored, media)
+		}
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to download media")
+			msg := fmt.Sprintf("Failed to download media %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
+			return
+		}
+
+		mimetype := media.(interface{ GetMimetype() string }).GetMimetype()
+		if mimetype == "" {
+			mimetype = http.DetectContentType(data)
+		}
+
+		if t.Binary {
+			w.Header().Set("Content-Type", mimetype)
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			if doc, ok := media.(*waE2E.DocumentMessage); ok && doc.GetFileName() != "" {
+				w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": doc.GetFileName()}))
+			}
+			w.WriteHeader(http.StatusOK)
+			_, err = io.Copy(w, bytes.NewReader(data))
+			if err != nil {
+				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to stream media")
+			}
+			return
+		}
+
+		dataURL := dataurl.New(data, mimetype)
+		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		// return
+	}
+}
+
+// React
+func (s *server) React() http.HandlerFunc {
+
+	type textStruct struct {
+		Phone string
+		Body  string
+		Id    string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		msgid := ""
+		var resp whatsmeow.SendResponse
+
+		decoder := json.NewDecoder(r.Body)
+		var t textStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.Phone == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+			return
+		}
+
+		if t.Body == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing body in payload"))
+			return
+		}
+
+		recipient, ok := parseJID(t.Phone)
+		if !ok {
+			log.Error().Msg(fmt.Sprintf("%s", err))
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		if t.Id == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing id in payload"))
+			return
+		} else {
+			msgid = t.Id
+		}
+
+		fromMe := false
+		if strings.HasPrefix(msgid, "me:") {
+			fromMe = true
+			msgid = msgid[len("me:"):]
+		}
+		reaction := t.Body
+		if reaction == "remove" {
+			reaction = ""
+		}
+
+		msg := &waE2E.Message{
+			ReactionMessage: &waE2E.ReactionMessage{
+				Key: &waCommon.MessageKey{
+					RemoteJID: proto.String(recipient.String()),
+					FromMe:    proto.Bool(fromMe),
+					ID:        proto.String(msgid),
+				},
+				Text:              proto.String(reaction),
+				GroupingKey:       proto.String(reaction),
+				SenderTimestampMS: proto.Int64(time.Now().UnixMilli()),
+			},
+		}
+
+		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			return
+		}
+
+		// log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
+		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// Edits the text or caption of a previously sent message. Messages still in the store keep their type and ContextInfo
+func (s *server) EditMessage() http.HandlerFunc {
+
+	type editStruct struct {
+		Phone string
+		Id    string
+		Body  string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t editStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.Phone == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+			return
+		}
+
+		if t.Id == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing id in payload"))
+			return
+		}
+
+		if t.Body == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing body in payload"))
+			return
+		}
+
+		recipient, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+			return
+		}
+
+		stored, found := getStoredMessage(userid, t.Id)
+		if found && !stored.Info.IsFromMe {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("only messages sent by this account can be edited"))
+			return
+		}
+		if found && stored.Info.Chat.ToNonAD() != recipient.ToNonAD() {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("message was not sent to the given phone"))
+			return
+		}
+
+		newContent := &waE2E.Message{Conversation: proto.String(t.Body)}
+		if found {
+			newContent, err = editedContent(stored.Message, t.Body)
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+		}
+		resp, err := clientPointer[userid].SendMessage(context.Background(), recipient, clientPointer[userid].BuildEdit(recipient, t.Id, newContent))
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending edit: %v", err)))
+			return
+		}
+
+		if found {
+			edited := *stored
+			edited.Message = newContent
+			edited.IsEdit = true
+			storeMessage(userid, &edited)
+		}
+
+		response := map[string]interface{}{"Details": "Edited", "Timestamp": resp.Timestamp, "Id": t.Id}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// Revokes (deletes for everyone) a message. Group admins can also revoke messages sent by other participants
+func (s *server) RevokeMessage() http.HandlerFunc {
+
+	type revokeStruct struct {
+		Phone       string
+		Id          string
+		Participant string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t revokeStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.Phone == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+			return
+		}
+
+		if t.Id == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing id in payload"))
+			return
+		}
+
+		recipient, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+			return
+		}
+
+		// The sender is left empty for our own messages, which is what BuildRevoke expects
+		sender := types.EmptyJID
+		if t.Participant != "" {
+			sender, ok = parseJID(t.Participant)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse participant"))
+				return
+			}
+		} else if stored, found := getStoredMessage(userid, t.Id); found && !stored.Info.IsFromMe {
+			sender = stored.Info.Sender
+		}
+
+		ownJID := clientPointer[userid].Store.ID
+		if !sender.IsEmpty() && ownJID != nil && sender.User != ownJID.User && recipient.Server != types.GroupServer {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("messages from other users can only be revoked in groups"))
+			return
+		}
+
+		resp, err := clientPointer[userid].SendMessage(context.Background(), recipient, clientPointer[userid].BuildRevoke(recipient, sender, t.Id))
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending revoke: %v", err)))
+			return
+		}
+
+		deleteStoredMessage(userid, t.Id)
+
+		response := map[string]interface{}{"Details": "Revoked", "Timestamp": resp.Timestamp, "Id": t.Id}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// Forwards a stored message, or one passed in full, to another chat
+func (s *server) ForwardMessage() http.HandlerFunc {
+
+	type forwardStruct struct {
+		Phone     string
+		MessageId string
+		Message   *waE2E.Message
+		Timestamp time.Time
+		Id        string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		msgid := ""
+		var resp whatsmeow.SendResponse
+
+		decoder := json.NewDecoder(r.Body)
+		var t forwardStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.Phone == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+			return
+		}
+
+		recipient, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+			return
+		}
+
+		var stored *events.Message
+		source := t.Message
+		sent := t.Timestamp
+		if source == nil {
+			if t.MessageId == "" {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("missing messageid or message in payload"))
+				return
+			}
+			stored, ok = getStoredMessage(userid, t.MessageId)
+			if !ok {
+				s.Respond(w, r, http.StatusNotFound, errors.New("message not found, pass its content in message"))
+				return
+			}
+			if stored.IsViewOnce {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("view once messages cannot be forwarded"))
+				return
+			}
+			source = stored.Message
+		} else if t.MessageId != "" {
+			// A message passed in full still has its age looked up, for media without a key timestamp
+			stored, _ = getStoredMessage(userid, t.MessageId)
+		}
+		if stored != nil {
+			sent = stored.Info.Timestamp
+		}
+
+		if source.GetProtocolMessage() != nil || source.GetReactionMessage() != nil || source.GetPollUpdateMessage() != nil || getPollCreation(source) != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("this kind of message cannot be forwarded"))
+			return
+		}
+
+		msg := proto.Clone(source).(*waE2E.Message)
+		msg.MessageContextInfo = nil
+
+		if media := getDownloadable(msg); media != nil && mediaKeyExpired(media, sent) {
+			log.Info().Str("id", t.MessageId).Msg("Media keys expired, uploading media again before forwarding")
+			err = reuploadMedia(userid, stored, media)
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+
+		// Forwarded messages keep no reply or mentions, only how many times they were forwarded
+		setContextInfo(msg, &waE2E.ContextInfo{
+			IsForwarded:     proto.Bool(true),
+			ForwardingScore: proto.Uint32(getContextInfo(source).GetForwardingScore() + 1),
+		})
+		applyDisappearingTimer(userid, recipient, msg)
+
+		if t.Id == "" {
+			msgid = whatsmeow.GenerateMessageID()
+		} else {
+			msgid = t.Id
+		}
+
+		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			return
+		}
+		storeSentMessage(userid, recipient, resp, msg)
+
+		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// Sets the disappearing messages timer of a chat or group
+func (s *server) SetDisappearingTimer() http.HandlerFunc {
+
+	type timerStruct struct {
+		Phone    string
+		Duration string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t timerStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.Phone == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+			return
+		}
+
+		recipient, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+			return
+		}
+
+		timer, ok := whatsmeow.ParseDisappearingTimerString(t.Duration)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("duration must be one of off, 24h, 7d or 90d"))
+			return
+		}
+
+		err = clientPointer[userid].SetDisappearingTimer(recipient, timer)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to set disappearing timer: %v", err)))
+			return
+		}
+		storeDisappearingTimer(userid, recipient, uint32(timer.Seconds()))
+
+		response := map[string]interface{}{"Details": "Disappearing timer set", "Duration": int(timer.Seconds())}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Sets the disappearing messages timer used for new chats
+func (s *server) SetDefaultDisappearingTimer() http.HandlerFunc {
+
+	type timerStruct struct {
+		Duration string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t timerStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		timer, ok := whatsmeow.ParseDisappearingTimerString(t.Duration)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("duration must be one of off, 24h, 7d or 90d"))
+			return
+		}
+
+		err = clientPointer[userid].SetDefaultDisappearingTimer(timer)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to set default disappearing timer: %v", err)))
+			return
+		}
+		storeDefaultDisappearingTimer(userid, uint32(timer.Seconds()))
+		err = s.service.SetDisappearingTimer(userid, int(timer.Seconds()))
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to save default disappearing timer")
+		}
+
+		response := map[string]interface{}{"Details": "Default disappearing timer set", "Duration": int(timer.Seconds())}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Organizes a chat through app state: archive, pin, mute, mark as read or unread, clear or delete it
+func (s *server) UpdateChat(action string) http.HandlerFunc {
+
+	type chatStruct struct {
+		Phone       string
+		Duration    string
+		KeepStarred bool
+		DeleteMedia bool
+	}
+
+	details := map[string]string{
+		"archive":   "Chat archived",
+		"unarchive": "Chat unarchived",
+		"pin":       "Chat pinned",
+		"unpin":     "Chat unpinned",
+		"mute":      "Chat muted",
+		"unmute":    "Chat unmuted",
+		"read":      "Chat marked as read",
+		"unread":    "Chat marked as unread",
+		"clear":     "Chat cleared",
+		"delete":    "Chat deleted",
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t chatStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.Phone == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+			return
+		}
+
+		chat, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+			return
+		}
+
+		var muteDuration time.Duration
+		if action == "mute" {
+			muteDuration, err = parseMuteDuration(t.Duration)
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		patch, err := buildChatPatch(userid, chat, action, muteDuration, t.KeepStarred, t.DeleteMedia)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		err = clientPointer[userid].SendAppState(patch)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to %s chat: %v", action, err)))
+			return
+		}
+		if action == "delete" {
+			deleteLastMessage(userid, chat.String())
+		}
+
+		response := map[string]interface{}{"Details": details[action], "JID": chat.String()}
+		if action == "mute" && muteDuration > 0 {
+			response["MuteEnd"] = time.Now().Add(muteDuration).Unix()
+		} else if action == "mute" {
+			response["MuteEnd"] = -1
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Mark messages as read
+func (s *server) MarkRead() http.HandlerFunc {
+
+	type markReadStruct struct {
+		Id     []string
+		Chat   types.JID
+		Sender types.JID
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t markReadStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.Chat.String() == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing chat in payload"))
+			return
+		}
+
+		if len(t.Id) < 1 {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing id in payload"))
+			return
+		}
+
+		err = clientPointer[userid].MarkRead(t.Id, time.Now(), t.Chat, t.Sender)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("failure marking messages as read"))
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Message(s) marked as read"}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		// return
+	}
+}
+
+// Posts a text, image or video status update
+func (s *server) SendStatus() http.HandlerFunc {
+
+	type statusStruct struct {
+		Type            string
+		Text            string
+		BackgroundColor string
+		TextColor       string
+		Font            string
+		Image           string
+		Video           string
+		Url             string
+		MediaId         string
+		Caption         string
+		Mimetype        string
+		JpegThumbnail   []byte
+		Recipients      interface{}
+		Id              string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+		msgid := ""
+		var resp whatsmeow.SendResponse
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		var t statusStruct
+		upload, err := decodeMediaPayload(w, r, &t, "Media")
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		// whatsmeow always sends statuses to the audience of the status privacy settings, which it can neither
+		// override per message nor change, so a recipient list is refused rather than ignored
+		if t.Recipients != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("status recipients cannot be chosen per status, set the audience in the status privacy settings of the phone"))
+			return
+		}
+
+		kind := strings.ToLower(t.Type)
+		if kind == "" {
+			switch {
+			case t.Image != "":
+				kind = "image"
+			case t.Video != "":
+				kind = "video"
+			case upload != nil && strings.HasPrefix(upload.Mimetype, "video/"):
+				kind = "video"
+			case upload != nil:
+				kind = "image"
+			default:
+				kind = "text"
+			}
+		}
+
+		if t.Id == "" {
+			msgid = whatsmeow.GenerateMessageID()
+		} else {
+			msgid = t.Id
+		}
+
+		var msg *waE2E.Message
+		var uploaded *uploadedMedia
+		switch kind {
+		case "text":
+			if t.Text == "" {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("missing text in payload"))
+				return
+			}
+			background := uint32(0xFF075E54)
+			if t.BackgroundColor != "" {
+				background, err = parseArgbColor(t.BackgroundColor)
+				if err != nil {
+					s.Respond(w, r, http.StatusBadRequest, err)
+					return
+				}
+			}
+			foreground := uint32(0xFFFFFFFF)
+			if t.TextColor != "" {
+				foreground, err = parseArgbColor(t.TextColor)
+				if err != nil {
+					s.Respond(w, r, http.StatusBadRequest, err)
+					return
+				}
+			}
+			font := waE2E.ExtendedTextMessage_SYSTEM
+			if t.Font != "" {
+				font, err = parseStatusFont(t.Font)
+				if err != nil {
+					s.Respond(w, r, http.StatusBadRequest, err)
+					return
+				}
+			}
+			msg = &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+				Text:           proto.String(t.Text),
+				BackgroundArgb: proto.Uint32(background),
+				TextArgb:       proto.Uint32(foreground),
+				Font:           font.Enum(),
+			}}
+		case "image", "video":
+			encoded := t.Image
+			if kind == "video" {
+				encoded = t.Video
+			}
+			prepared, status, err := getSendMedia(userid, upload, encoded, t.Url, t.MediaId, "", kind)
+			if err != nil {
+				s.Respond(w, r, status, err)
+				return
+			}
+			uploaded = prepared
+			if t.Mimetype != "" {
+				prepared.Mimetype = t.Mimetype
+			}
+			if t.JpegThumbnail != nil {
+				prepared.Thumbnail = t.JpegThumbnail
+			}
+			if kind == "image" {
+				msg = &waE2E.Message{ImageMessage: &waE2E.ImageMessage{
+					Caption:       proto.String(t.Caption),
+					URL:           proto.String(prepared.URL),
+					DirectPath:    proto.String(prepared.DirectPath),
+					MediaKey:      prepared.MediaKey,
+					Mimetype:      proto.String(prepared.Mimetype),
+					FileEncSHA256: prepared.FileEncSHA256,
+					FileSHA256:    prepared.FileSHA256,
+					FileLength:    proto.Uint64(prepared.FileLength),
+					JPEGThumbnail: prepared.Thumbnail,
+				}}
+				if prepared.Width > 0 {
+					msg.ImageMessage.Width = proto.Uint32(prepared.Width)
+					msg.ImageMessage.Height = proto.Uint32(prepared.Height)
+				}
+			} else {
+				msg = &waE2E.Message{VideoMessage: &waE2E.VideoMessage{
+					Caption:       proto.String(t.Caption),
+					URL:           proto.String(prepared.URL),
+					DirectPath:    proto.String(prepared.DirectPath),
+					MediaKey:      prepared.MediaKey,
+					Mimetype:      proto.String(prepared.Mimetype),
+					FileEncSHA256: prepared.FileEncSHA256,
+					FileSHA256:    prepared.FileSHA256,
+					FileLength:    proto.Uint64(prepared.FileLength),
+					JPEGThumbnail: prepared.Thumbnail,
+				}}
+			}
+		default:
+			s.Respond(w, r, http.StatusBadRequest, errors.New("type must be one of text, image or video"))
+			return
+		}
+
+		resp, err = clientPointer[userid].SendMessage(context.Background(), types.StatusBroadcastJID, msg, whatsmeow.SendRequestExtra{ID: msgid})
+		if err != nil {
+			forgetUploadedMedia(userid, uploaded)
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending status: %v", err)))
+			return
+		}
+		storeSentMessage(userid, types.StatusBroadcastJID, resp, msg)
+
+		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Gets who status updates are sent to, as configured in the status privacy settings of the phone
+func (s *server) GetStatusPrivacy() http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		privacy, err := clientPointer[userid].GetStatusPrivacy()
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to get status privacy: %v", err)))
+			return
+		}
+
+		responseJson, err := json.Marshal(privacy)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Lists the newsletters (channels) the account follows or owns
+func (s *server) ListNewsletters() http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		newsletters, err := clientPointer[userid].GetSubscribedNewsletters()
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to get newsletters: %v", err)))
+			return
+		}
+
+		response := map[string]interface{}{"Newsletters": newsletters}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Gets newsletter information by its JID or invite link
+func (s *server) GetNewsletterInfo() http.HandlerFunc {
+
+	type newsletterInfoStruct struct {
+		NewsletterJID string
+		Invite        string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t newsletterInfoStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		var info *types.NewsletterMetadata
+		if t.Invite != "" {
+			info, err = clientPointer[userid].GetNewsletterInfoWithInvite(newsletterInviteKey(t.Invite))
+		} else {
+			newsletter, status, jidErr := getNewsletterJID(userid, t.NewsletterJID, "")
+			if jidErr != nil {
+				s.Respond(w, r, status, jidErr)
+				return
+			}
+			info, err = clientPointer[userid].GetNewsletterInfo(newsletter)
+		}
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to get newsletter info: %v", err)))
+			return
+		}
+
+		responseJson, err := json.Marshal(info)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Follows or unfollows a newsletter
+func (s *server) FollowNewsletter(follow bool) http.HandlerFunc {
+
+	type followStruct struct {
+		NewsletterJID string
+		Invite        string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t followStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		newsletter, status, err := getNewsletterJID(userid, t.NewsletterJID, t.Invite)
+		if err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
+
+		details := "Followed"
+		if follow {
+			err = clientPointer[userid].FollowNewsletter(newsletter)
+		} else {
+			details = "Unfollowed"
+			err = clientPointer[userid].UnfollowNewsletter(newsletter)
+		}
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to update newsletter subscription: %v", err)))
+			return
+		}
+
+		response := map[string]interface{}{"Details": details, "NewsletterJID": newsletter.String()}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Mutes or unmutes notifications of a followed newsletter
+func (s *server) MuteNewsletter() http.HandlerFunc {
+
+	type muteStruct struct {
+		NewsletterJID string
+		Mute          bool
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t muteStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		newsletter, status, err := getNewsletterJID(userid, t.NewsletterJID, "")
+		if err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
+
+		err = clientPointer[userid].NewsletterToggleMute(newsletter, t.Mute)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to mute newsletter: %v", err)))
+			return
+		}
+
+		details := "Unmuted"
+		if t.Mute {
+			details = "Muted"
+		}
+		response := map[string]interface{}{"Details": details}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Creates a newsletter owned by the account
+func (s *server) CreateNewsletter() http.HandlerFunc {
+
+	type createNewsletterStruct struct {
+		Name        string
+		Description string
+		Picture     string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t createNewsletterStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.Name == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing name in payload"))
+			return
+		}
+
+		params := whatsmeow.CreateNewsletterParams{Name: t.Name, Description: t.Description}
+		if t.Picture != "" {
+			picture, err := loadMedia(nil, t.Picture, "", "image")
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+			params.Picture = picture.Data
+		}
+
+		info, err := clientPointer[userid].CreateNewsletter(params)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to create newsletter: %v", err)))
+			return
+		}
+
+		responseJson, err := json.Marshal(info)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Posts a text or media update to a newsletter owned or administered by the account
+func (s *server) SendNewsletterMessage() http.HandlerFunc {
+
+	type newsletterMessageStruct struct {
+		NewsletterJID string
+		Type          string
+		Body          string
+		Image         string
+		Video         string
+		Document      string
+		Url           string
+		Caption       string
+		FileName      string
+		Mimetype      string
+		Id            string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
 		msgid := ""
 		var resp whatsmeow.SendResponse
 
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		var t newsletterMessageStruct
+		upload, err := decodeMediaPayload(w, r, &t, "Media")
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		newsletter, status, err := getNewsletterJID(userid, t.NewsletterJID, "")
+		if err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
+
+		kind := strings.ToLower(t.Type)
+		if kind == "" {
+			switch {
+			case t.Image != "":
+				kind = "image"
+			case t.Video != "":
+				kind = "video"
+			case t.Document != "":
+				kind = "document"
+			case upload != nil && strings.HasPrefix(upload.Mimetype, "image/"):
+				kind = "image"
+			case upload != nil && strings.HasPrefix(upload.Mimetype, "video/"):
+				kind = "video"
+			case upload != nil:
+				kind = "document"
+			default:
+				kind = "text"
+			}
+		}
+
+		if t.Id == "" {
+			msgid = whatsmeow.GenerateMessageID()
+		} else {
+			msgid = t.Id
+		}
+
+		var msg *waE2E.Message
+		var handle string
+		switch kind {
+		case "text":
+			if t.Body == "" {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("missing body in payload"))
+				return
+			}
+			msg = &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{Text: proto.String(t.Body)}}
+		case "image", "video", "document":
+			encoded := map[string]string{"image": t.Image, "video": t.Video, "document": t.Document}[kind]
+			media, err := loadMedia(upload, encoded, t.Url, kind)
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+			if t.FileName != "" {
+				media.FileName = t.FileName
+			}
+			prepared, err := prepareNewsletterMedia(userid, media, kind)
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			if t.Mimetype != "" {
+				prepared.Mimetype = t.Mimetype
+			}
+			handle = prepared.Handle
+
+			switch kind {
+			case "image":
+				msg = &waE2E.Message{ImageMessage: &waE2E.ImageMessage{
+					Caption:       proto.String(t.Caption),
+					URL:           proto.String(prepared.URL),
+					DirectPath:    proto.String(prepared.DirectPath),
+					Mimetype:      proto.String(prepared.Mimetype),
+					FileSHA256:    prepared.FileSHA256,
+					FileLength:    proto.Uint64(prepared.FileLength),
+					JPEGThumbnail: prepared.Thumbnail,
+				}}
+				if prepared.Width > 0 {
+					msg.ImageMessage.Width = proto.Uint32(prepared.Width)
+					msg.ImageMessage.Height = proto.Uint32(prepared.Height)
+				}
+			case "video":
+				msg = &waE2E.Message{VideoMessage: &waE2E.VideoMessage{
+					Caption:    proto.String(t.Caption),
+					URL:        proto.String(prepared.URL),
+					DirectPath: proto.String(prepared.DirectPath),
+					Mimetype:   proto.String(prepared.Mimetype),
+					FileSHA256: prepared.FileSHA256,
+					FileLength: proto.Uint64(prepared.FileLength),
+				}}
+			case "document":
+				msg = &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{
+					Caption:       proto.String(t.Caption),
+					FileName:      proto.String(prepared.FileName),
+					URL:           proto.String(prepared.URL),
+					DirectPath:    proto.String(prepared.DirectPath),
+					Mimetype:      proto.String(prepared.Mimetype),
+					FileSHA256:    prepared.FileSHA256,
+					FileLength:    proto.Uint64(prepared.FileLength),
+					JPEGThumbnail: prepared.Thumbnail,
+				}}
+			}
+		default:
+			s.Respond(w, r, http.StatusBadRequest, errors.New("type must be one of text, image, video or document"))
+			return
+		}
+
+		resp, err = clientPointer[userid].SendMessage(context.Background(), newsletter, msg, whatsmeow.SendRequestExtra{ID: msgid, MediaHandle: handle})
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid, "ServerId": resp.ServerID}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Gets the most recent messages of a newsletter
+func (s *server) GetNewsletterMessages() http.HandlerFunc {
+
+	type newsletterMessagesStruct struct {
+		NewsletterJID string
+		Count         int
+		Before        int
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t newsletterMessagesStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		newsletter, status, err := getNewsletterJID(userid, t.NewsletterJID, "")
+		if err != nil {
+			s.Respond(w, r, status, err)
+			return
+		}
+
+		if t.Count <= 0 || t.Count > 100 {
+			t.Count = 20
+		}
+
+		messages, err := clientPointer[userid].GetNewsletterMessages(newsletter, &whatsmeow.GetNewsletterMessagesParams{Count: t.Count, Before: t.Before})
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to get newsletter messages: %v", err)))
+			return
+		}
+
+		response := map[string]interface{}{"Messages": messages}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// List groups. With paging or search parameters the groups are paginated summaries, and participants are only included
+// when asked for
+func (s *server) ListGroups() http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		query := r.URL.Query()
+		page, err := strconv.Atoi(query.Get("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 {
+			limit = 50
+		} else if limit > 500 {
+			limit = 500
+		}
+		search := strings.ToLower(query.Get("search"))
+		withParticipants := query.Get("participants") == "true"
+
+		resp, err := getJoinedGroups(userid, query.Get("refresh") == "true")
+
+		if err != nil {
+			msg := fmt.Sprintf("Failed to get group list: %v", err)
+			log.Error().Msg(msg)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		// Without any of the new parameters the full group information is returned, as it always was
+		if !query.Has("page") && !query.Has("limit") && !query.Has("search") && !query.Has("participants") {
+			full := make([]types.GroupInfo, 0, len(resp))
+			for _, info := range resp {
+				full = append(full, *info)
+			}
+			responseJson, err := json.Marshal(map[string]interface{}{"Groups": full})
+			if err != nil {
+				s.Respond(w, r, http.StatusInternalServerError, err)
+			} else {
+				s.Respond(w, r, http.StatusOK, string(responseJson))
+			}
+			return
+		}
+
+		groups := make([]*types.GroupInfo, 0, len(resp))
+		for _, info := range resp {
+			if search == "" || strings.Contains(strings.ToLower(info.Name), search) {
+				groups = append(groups, info)
+			}
+		}
+		sort.Slice(groups, func(i, j int) bool {
+			first, second := strings.ToLower(groups[i].Name), strings.ToLower(groups[j].Name)
+			if first == second {
+				return groups[i].JID.String() < groups[j].JID.String()
+			}
+			return first < second
+		})
+
+		summaries := make([]map[string]interface{}, 0, limit)
+		for i := (page - 1) * limit; i < len(groups) && i < page*limit; i++ {
+			summaries = append(summaries, groupSummary(groups[i], clientPointer[userid].Store.ID, withParticipants))
+		}
+
+		response := map[string]interface{}{"Groups": summaries, "Total": len(groups), "Page": page, "Limit": limit}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// Get group info
+func (s *server) GetGroupInfo() http.HandlerFunc {
+
+	type getGroupInfoStruct struct {
+		GroupJID string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t getGroupInfoStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		resp, err := clientPointer[userid].GetGroupInfo(group)
+
+		if err != nil {
+			msg := fmt.Sprintf("Failed to get group info: %v", err)
+			log.Error().Msg(msg)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		responseJson, err := json.Marshal(resp)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// Get group invite link
+func (s *server) GetGroupInviteLink() http.HandlerFunc {
+
+	type getGroupInfoStruct struct {
+		GroupJID string
+		Reset    bool
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t getGroupInfoStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		resp, err := clientPointer[userid].GetGroupInviteLink(group, t.Reset)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to get group invite link")
+			msg := fmt.Sprintf("Failed to get group invite link: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		response := map[string]interface{}{"InviteLink": resp}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// Set group photo
+func (s *server) SetGroupPhoto() http.HandlerFunc {
+
+	type setGroupPhotoStruct struct {
+		GroupJID string
+		Image    string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t setGroupPhotoStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		if t.Image == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing image in payload"))
+			return
+		}
+
+		var filedata []byte
+
+		if t.Image[0:13] == "data:image/jp" {
+			dataURL, err := dataurl.DecodeString(t.Image)
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode base64 encoded data from payload"))
+				return
+			} else {
+				filedata = dataURL.Data
+			}
+		} else {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("image data should start with \"data:image/jpeg;base64,\""))
+			return
+		}
+
+		picture_id, err := clientPointer[userid].SetGroupPhoto(group, filedata)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group photo")
+			msg := fmt.Sprintf("Failed to set group photo: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Group Photo set successfully", "PictureID": picture_id}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// Set group name
+func (s *server) SetGroupName() http.HandlerFunc {
+
+	type setGroupNameStruct struct {
+		GroupJID string
+		Name     string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t setGroupNameStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		if t.Name == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing name in payload"))
+			return
+		}
+
+		err = clientPointer[userid].SetGroupName(group, t.Name)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group name")
+			msg := fmt.Sprintf("Failed to set group name: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Group Name set successfully"}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// Create group, group inside a community or, when community is set, a new community
+func (s *server) CreateGroup(community bool) http.HandlerFunc {
+
+	type createGroupStruct struct {
+		Name         string
+		Participants []string
+		CommunityJID string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t createGroupStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		if t.Name == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing name in payload"))
+			return
+		}
+
+		if t.CommunityJID != "" && community {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("communities cannot be created inside another community"))
+			return
+		}
+
+		req := whatsmeow.ReqCreateGroup{Name: t.Name}
+		req.IsParent = community
+
+		if t.CommunityJID != "" {
+			parent, ok := parseJID(t.CommunityJID)
+			if !ok {
+				s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse community jid"))
+				return
+			}
+			req.LinkedParentJID = parent
+		}
+
+		// Communities can be created empty, members join through their groups
+		if !community || len(t.Participants) > 0 {
+			req.Participants, err = parseParticipants(t.Participants)
+			if err != nil {
+				s.Respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		info, err := clientPointer[userid].CreateGroup(req)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to create group")
+			msg := fmt.Sprintf("Failed to create group: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		response := map[string]interface{}{"Group": info, "Participants": participantResults(info.Participants)}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// Add, remove, promote or demote group participants
+func (s *server) UpdateGroupParticipants() http.HandlerFunc {
+
+	type updateParticipantsStruct struct {
+		GroupJID     string
+		Action       string
+		Participants []string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t updateParticipantsStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		action := whatsmeow.ParticipantChange(strings.ToLower(t.Action))
+		switch action {
+		case whatsmeow.ParticipantChangeAdd, whatsmeow.ParticipantChangeRemove, whatsmeow.ParticipantChangePromote, whatsmeow.ParticipantChangeDemote:
+		default:
+			s.Respond(w, r, http.StatusBadRequest, errors.New("action must be one of add, remove, promote or demote"))
+			return
+		}
+
+		participants, err := parseParticipants(t.Participants)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		resp, err := clientPointer[userid].UpdateGroupParticipants(group, participants, action)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to update group participants")
+			msg := fmt.Sprintf("Failed to update group participants: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		response := map[string]interface{}{"Participants": participantResults(resp)}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// Leave group
+func (s *server) LeaveGroup() http.HandlerFunc {
+
+	type leaveGroupStruct struct {
+		GroupJID string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t leaveGroupStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		err = clientPointer[userid].LeaveGroup(group)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to leave group")
+			msg := fmt.Sprintf("Failed to leave group: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Left group successfully"}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// Set group description
+func (s *server) SetGroupTopic() http.HandlerFunc {
+
+	type setGroupTopicStruct struct {
+		GroupJID string
+		Topic    string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t setGroupTopicStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		// An empty topic removes the description
+		err = clientPointer[userid].SetGroupTopic(group, "", "", t.Topic)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group topic")
+			msg := fmt.Sprintf("Failed to set group topic: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Group Topic set successfully"}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// Set group settings: announce (only admins send messages), locked (only admins edit group info) and join approval
+// (admins approve new members)
+func (s *server) SetGroupSettings() http.HandlerFunc {
+
+	type setGroupSettingsStruct struct {
+		GroupJID     string
+		Announce     *bool
+		Locked       *bool
+		JoinApproval *bool
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t setGroupSettingsStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		if t.Announce == nil && t.Locked == nil && t.JoinApproval == nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing announce, locked or joinapproval in payload"))
+			return
+		}
+
+		if t.Announce != nil {
+			err = clientPointer[userid].SetGroupAnnounce(group, *t.Announce)
+			if err != nil {
+				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group announce")
+				msg := fmt.Sprintf("Failed to set group announce: %v", err)
+				s.Respond(w, r, http.StatusInternalServerError, msg)
+				return
+			}
+		}
+
+		if t.Locked != nil {
+			err = clientPointer[userid].SetGroupLocked(group, *t.Locked)
+			if err != nil {
+				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group locked")
+				msg := fmt.Sprintf("Failed to set group locked: %v", err)
+				s.Respond(w, r, http.StatusInternalServerError, msg)
+				return
+			}
+		}
+
+		if t.JoinApproval != nil {
+			err = setGroupJoinApproval(userid, group, *t.JoinApproval)
+			if err != nil {
+				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group join approval")
+				msg := fmt.Sprintf("Failed to set group join approval: %v", err)
+				s.Respond(w, r, http.StatusInternalServerError, msg)
+				return
+			}
+		}
+
+		response := map[string]interface{}{"Details": "Group Settings set successfully"}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// List pending requests to join a group
+func (s *server) GetGroupRequests() http.HandlerFunc {
+
+	type groupRequestsStruct struct {
+		GroupJID string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t groupRequestsStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		resp, err := clientPointer[userid].GetGroupRequestParticipants(group)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to get group requests")
+			msg := fmt.Sprintf("Failed to get group requests: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		requests := make([]string, 0, len(resp))
+		for _, jid := range resp {
+			requests = append(requests, jid.String())
+		}
+
+		response := map[string]interface{}{"Requests": requests}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// Approve or reject requests to join a group
+func (s *server) UpdateGroupRequests() http.HandlerFunc {
+
+	type updateGroupRequestsStruct struct {
+		GroupJID     string
+		Action       string
+		Participants []string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t updateGroupRequestsStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		action := whatsmeow.ParticipantRequestChange(strings.ToLower(t.Action))
+		if action != whatsmeow.ParticipantChangeApprove && action != whatsmeow.ParticipantChangeReject {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("action must be one of approve or reject"))
+			return
+		}
+
+		participants, err := parseParticipants(t.Participants)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		resp, err := clientPointer[userid].UpdateGroupRequestParticipants(group, participants, action)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to update group requests")
+			msg := fmt.Sprintf("Failed to update group requests: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		response := map[string]interface{}{"Participants": participantResults(resp)}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// Revoke group invite link, generating a new one
+func (s *server) RevokeGroupInviteLink() http.HandlerFunc {
+
+	type revokeInviteLinkStruct struct {
+		GroupJID string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		decoder := json.NewDecoder(r.Body)
+		var t revokeInviteLinkStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			return
+		}
+
+		resp, err := clientPointer[userid].GetGroupInviteLink(group, true)
+
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to revoke group invite link")
+			msg := fmt.Sprintf("Failed to revoke group invite link: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Group Invite Link revoked successfully", "InviteLink": resp}
+		responseJson, err := json.Marshal(response)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+
+		// return
+	}
+}
+
+// Get group info from an invite link, without joining
+func (s *server) GetGroupInviteInfo() http.HandlerFunc {
+
+	type groupInviteStruct struct {
+		Invite string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
 		decoder := json.NewDecoder(r.Body)
-		var t textStruct
+		var t groupInviteStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
+		if t.Invite == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing invite in payload"))
 			return
 		}
 
-		if t.Body == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing body in payload"))
-			return
-		}
+		resp, err := clientPointer[userid].GetGroupInfoFromLink(strings.TrimSpace(t.Invite))
 
-		recipient, ok := parseJID(t.Phone)
-		if !ok {
-			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+		if err != nil {
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to get group invite info")
+			msg := fmt.Sprintf("Failed to get group invite info: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		if t.Id == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing id in payload"))
-			return
+		responseJson, err := json.Marshal(resp)
+
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
-			msgid = t.Id
+			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
 
-		fromMe := false
-		if strings.HasPrefix(msgid, "me:") {
-			fromMe = true
-			msgid = msgid[len("me:"):]
+		// return
+	}
+}
+
+// Join group with an invite link
+func (s *server) JoinGroup() http.HandlerFunc {
+
+	type joinGroupStruct struct {
+		Invite string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
 		}
-		reaction := t.Body
-		if reaction == "remove" {
-			reaction = ""
+
+		decoder := json.NewDecoder(r.Body)
+		var t joinGroupStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
 		}
 
-		msg := &waE2E.Message{
-			ReactionMessage: &waE2E.ReactionMessage{
-				Key: &waCommon.MessageKey{
-					RemoteJID: proto.String(recipient.String()),
-					FromMe:    proto.Bool(fromMe),
-					ID:        proto.String(msgid),
-				},
-				Text:              proto.String(reaction),
-				GroupingKey:       proto.String(reaction),
-				SenderTimestampMS: proto.Int64(time.Now().UnixMilli()),
-			},
+		if t.Invite == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing invite in payload"))
+			return
 		}
 
-		resp, err = clientPointer[userid].SendMessage(context.Background(), recipient, msg)
+		group, err := clientPointer[userid].JoinGroupWithLink(strings.TrimSpace(t.Invite))
+
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to join group")
+			msg := fmt.Sprintf("Failed to join group: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		// log.Info().Str("timestamp", fmt.Sprintf("%d", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
-		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp, "Id": msgid}
+		response := map[string]interface{}{"Details": "Joined group successfully", "GroupJID": group.String()}
 		responseJson, err := json.Marshal(response)
+
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
@@ -2668,13 +5522,12 @@ func (s *server) React() http.HandlerFunc {
 	}
 }
 
-// Mark messages as read
-func (s *server) MarkRead() http.HandlerFunc {
+// Link or unlink a group to a community
+func (s *server) LinkCommunityGroup(link bool) http.HandlerFunc {
 
-	type markReadStruct struct {
-		Id     []string
-		Chat   types.JID
-		Sender types.JID
+	type linkGroupStruct struct {
+		CommunityJID string
+		GroupJID     string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -2688,45 +5541,58 @@ func (s *server) MarkRead() http.HandlerFunc {
 		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t markReadStruct
+		var t linkGroupStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		if t.Chat.String() == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing chat in payload"))
+		community, ok := parseJID(t.CommunityJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse community jid"))
 			return
 		}
 
-		if len(t.Id) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing id in payload"))
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
 			return
 		}
 
-		err = clientPointer[userid].MarkRead(t.Id, time.Now(), t.Chat, t.Sender)
+		details := "Group linked successfully"
+		if link {
+			err = clientPointer[userid].LinkGroup(community, group)
+		} else {
+			details = "Group unlinked successfully"
+			err = clientPointer[userid].UnlinkGroup(community, group)
+		}
+
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("failure marking messages as read"))
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to update community groups")
+			msg := fmt.Sprintf("Failed to update community groups: %v", err)
+			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		response := map[string]interface{}{"Details": "Message(s) marked as read"}
+		response := map[string]interface{}{"Details": details}
 		responseJson, err := json.Marshal(response)
+
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
+
 		// return
 	}
 }
 
-// List groups
-func (s *server) ListGroups() http.HandlerFunc {
+// List the groups of a community, including its announcement group
+func (s *server) GetCommunitySubGroups() http.HandlerFunc {
 
-	type GroupCollection struct {
-		Groups []types.GroupInfo
+	type communityStruct struct {
+		CommunityJID string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -2739,21 +5605,38 @@ func (s *server) ListGroups() http.HandlerFunc {
 			return
 		}
 
-		resp, err := clientPointer[userid].GetJoinedGroups()
+		decoder := json.NewDecoder(r.Body)
+		var t communityStruct
+		err := decoder.Decode(&t)
+		if err != nil {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			return
+		}
+
+		community, ok := parseJID(t.CommunityJID)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse community jid"))
+			return
+		}
+
+		resp, err := clientPointer[userid].GetSubGroups(community)
 
 		if err != nil {
-			msg := fmt.Sprintf("Failed to get group list: %v", err)
-			log.Error().Msg(msg)
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to get community groups")
+			msg := fmt.Sprintf("Failed to get community groups: %v", err)
 			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		gc := new(GroupCollection)
-		for _, info := range resp {
-			gc.Groups = append(gc.Groups, *info)
+		// The announcement group is the default subgroup every community member belongs to
+		response := map[string]interface{}{"SubGroups": resp, "AnnouncementGroup": nil}
+		for _, group := range resp {
+			if group.IsDefaultSubGroup {
+				response["AnnouncementGroup"] = group
+			}
 		}
+		responseJson, err := json.Marshal(response)
 
-		responseJson, err := json.Marshal(gc)
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
@@ -2764,11 +5647,11 @@ func (s *server) ListGroups() http.HandlerFunc {
 	}
 }
 
-// Get group info
-func (s *server) GetGroupInfo() http.HandlerFunc {
+// List the participants of all the groups linked to a community
+func (s *server) GetCommunityParticipants() http.HandlerFunc {
 
-	type getGroupInfoStruct struct {
-		GroupJID string
+	type communityStruct struct {
+		CommunityJID string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -2782,29 +5665,30 @@ func (s *server) GetGroupInfo() http.HandlerFunc {
 		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t getGroupInfoStruct
+		var t communityStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		group, ok := parseJID(t.GroupJID)
+		community, ok := parseJID(t.CommunityJID)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse community jid"))
 			return
 		}
 
-		resp, err := clientPointer[userid].GetGroupInfo(group)
+		resp, err := clientPointer[userid].GetLinkedGroupsParticipants(community)
 
 		if err != nil {
-			msg := fmt.Sprintf("Failed to get group info: %v", err)
-			log.Error().Msg(msg)
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to get community participants")
+			msg := fmt.Sprintf("Failed to get community participants: %v", err)
 			s.Respond(w, r, http.StatusInternalServerError, msg)
 			return
 		}
 
-		responseJson, err := json.Marshal(resp)
+		response := map[string]interface{}{"Participants": jidStrings(resp)}
+		responseJson, err := json.Marshal(response)
 
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
@@ -2816,12 +5700,42 @@ func (s *server) GetGroupInfo() http.HandlerFunc {
 	}
 }
 
-// Get group invite link
-func (s *server) GetGroupInviteLink() http.HandlerFunc {
+// Lists the WhatsApp Business labels of the account and the chats they are applied to
+func (s *server) ListLabels() http.HandlerFunc {
 
-	type getGroupInfoStruct struct {
-		GroupJID string
-		Reset    bool
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		userid, _ := strconv.Atoi(txtid)
+
+		if clientPointer[userid] == nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			return
+		}
+
+		labels, err := getLabels(userid, r.URL.Query().Get("refresh") == "true")
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to get labels: %v", err)))
+			return
+		}
+
+		response := map[string]interface{}{"Labels": labels}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.Respond(w, r, http.StatusInternalServerError, err)
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+		return
+	}
+}
+
+// Creates a label
+func (s *server) CreateLabel() http.HandlerFunc {
+
+	type createLabelStruct struct {
+		Name  string
+		Color int32
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -2835,47 +5749,46 @@ func (s *server) GetGroupInviteLink() http.HandlerFunc {
 		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t getGroupInfoStruct
+		var t createLabelStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		group, ok := parseJID(t.GroupJID)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+		if t.Name == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing name in payload"))
+			return
+		}
+		if t.Color < 0 || t.Color >= labelColors {
+			s.Respond(w, r, http.StatusBadRequest, errors.New(fmt.Sprintf("color must be between 0 and %d", labelColors-1)))
 			return
 		}
 
-		resp, err := clientPointer[userid].GetGroupInviteLink(group, t.Reset)
-
+		id, err := createLabel(userid, t.Name, t.Color)
 		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to get group invite link")
-			msg := fmt.Sprintf("Failed to get group invite link: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.Respond(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
-		response := map[string]interface{}{"InviteLink": resp}
+		response := map[string]interface{}{"Details": "Label created", "ID": id, "Name": t.Name, "Color": t.Color}
 		responseJson, err := json.Marshal(response)
-
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
-
-		// return
+		return
 	}
 }
 
-// Set group photo
-func (s *server) SetGroupPhoto() http.HandlerFunc {
+// Renames, recolors or deletes a label
+func (s *server) EditLabel(deleted bool) http.HandlerFunc {
 
-	type setGroupPhotoStruct struct {
-		GroupJID string
-		Image    string
+	type editLabelStruct struct {
+		LabelID string
+		Name    string
+		Color   *int32
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -2889,67 +5802,62 @@ func (s *server) SetGroupPhoto() http.HandlerFunc {
 		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t setGroupPhotoStruct
+		var t editLabelStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		group, ok := parseJID(t.GroupJID)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+		if t.LabelID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing label id in payload"))
 			return
 		}
-
-		if t.Image == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing image in payload"))
+		if t.Color != nil && (*t.Color < 0 || *t.Color >= labelColors) {
+			s.Respond(w, r, http.StatusBadRequest, errors.New(fmt.Sprintf("color must be between 0 and %d", labelColors-1)))
 			return
 		}
 
-		var filedata []byte
-
-		if t.Image[0:13] == "data:image/jp" {
-			dataURL, err := dataurl.DecodeString(t.Image)
-			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode base64 encoded data from payload"))
-				return
-			} else {
-				filedata = dataURL.Data
-			}
-		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("image data should start with \"data:image/jpeg;base64,\""))
+		// The patch carries the whole label, so whatever is not being changed is taken from the current one
+		label, err := getLabel(userid, t.LabelID)
+		if err != nil {
+			s.Respond(w, r, http.StatusNotFound, err)
 			return
 		}
+		if t.Name != "" {
+			label.Name = t.Name
+		}
+		if t.Color != nil {
+			label.Color = *t.Color
+		}
 
-		picture_id, err := clientPointer[userid].SetGroupPhoto(group, filedata)
-
+		err = saveLabel(userid, t.LabelID, label.Name, label.Color, deleted)
 		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group photo")
-			msg := fmt.Sprintf("Failed to set group photo: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to update label: %v", err)))
 			return
 		}
 
-		response := map[string]interface{}{"Details": "Group Photo set successfully", "PictureID": picture_id}
+		response := map[string]interface{}{"Details": "Label updated", "ID": t.LabelID, "Name": label.Name, "Color": label.Color}
+		if deleted {
+			response = map[string]interface{}{"Details": "Label deleted", "ID": t.LabelID}
+		}
 		responseJson, err := json.Marshal(response)
-
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
-
-		// return
+		return
 	}
 }
 
-// Set group name
-func (s *server) SetGroupName() http.HandlerFunc {
+// Applies a label to or removes it from a chat, or a single message when MessageID is given
+func (s *server) ApplyLabel(labeled bool) http.HandlerFunc {
 
-	type setGroupNameStruct struct {
-		GroupJID string
-		Name     string
+	type applyLabelStruct struct {
+		LabelID   string
+		Phone     string
+		MessageID string
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -2963,43 +5871,55 @@ func (s *server) SetGroupName() http.HandlerFunc {
 		}
 
 		decoder := json.NewDecoder(r.Body)
-		var t setGroupNameStruct
+		var t applyLabelStruct
 		err := decoder.Decode(&t)
 		if err != nil {
 			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
 			return
 		}
 
-		group, ok := parseJID(t.GroupJID)
-		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse group jid"))
+		if t.LabelID == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing label id in payload"))
 			return
 		}
-
-		if t.Name == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing name in payload"))
+		if t.Phone == "" {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("missing phone in payload"))
 			return
 		}
 
-		err = clientPointer[userid].SetGroupName(group, t.Name)
+		chat, ok := parseJID(t.Phone)
+		if !ok {
+			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse phone"))
+			return
+		}
 
+		if t.MessageID != "" {
+			err = clientPointer[userid].SendAppState(appstate.BuildLabelMessage(chat, t.LabelID, t.MessageID, labeled))
+		} else {
+			err = clientPointer[userid].SendAppState(appstate.BuildLabelChat(chat, t.LabelID, labeled))
+			if err == nil {
+				applyLabelChat(userid, t.LabelID, chat, labeled)
+			}
+		}
 		if err != nil {
-			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to set group name")
-			msg := fmt.Sprintf("Failed to set group name: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to update label: %v", err)))
 			return
 		}
 
-		response := map[string]interface{}{"Details": "Group Name set successfully"}
+		response := map[string]interface{}{"Details": "Label removed", "ID": t.LabelID, "JID": chat.String()}
+		if labeled {
+			response["Details"] = "Label applied"
+		}
+		if t.MessageID != "" {
+			response["MessageID"] = t.MessageID
+		}
 		responseJson, err := json.Marshal(response)
-
 		if err != nil {
 			s.Respond(w, r, http.StatusInternalServerError, err)
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
-
-		// return
+		return
 	}
 }
 
@@ -1,5 +1,45 @@
 package main
 
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"wuzapi/database"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/vincent-petithory/dataurl"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
 func Find(slice []string, val string) bool {
 	for _, item := range slice {
 		if item == val {
@@ -33,3 +73,2578 @@ func callHookFile(myurl string, payload map[string]string, id int, file string)
 		"file": file,
 	}).SetFormData(payload).Post(myurl)
 }
+
+// Most messages kept in memory across all sessions. When it is reached the oldest tenth is dropped
+const maxStoredMessages = 50000
+
+// Stores a received or sent message so it can be referenced later by its id. Only what downloading, quoting, editing
+// and forwarding need is kept, not the raw message nor the history sync information
+func storeMessage(userid int, evt *events.Message) {
+	if messagecache.ItemCount() >= maxStoredMessages {
+		trimMessageCache()
+	}
+	stored := &events.Message{
+		Info:                  evt.Info,
+		Message:               evt.Message,
+		IsEphemeral:           evt.IsEphemeral,
+		IsViewOnce:            evt.IsViewOnce,
+		IsViewOnceV2:          evt.IsViewOnceV2,
+		IsViewOnceV2Extension: evt.IsViewOnceV2Extension,
+		IsDocumentWithCaption: evt.IsDocumentWithCaption,
+		IsEdit:                evt.IsEdit,
+	}
+	messagecache.Set(fmt.Sprintf("%d:%s", userid, evt.Info.ID), stored, cache.DefaultExpiration)
+	storeLastMessage(userid, evt.Info)
+	if poll := getPollCreation(evt.Message); poll != nil {
+		storePoll(userid, evt.Info.ID, poll)
+	}
+}
+
+// Gets a previously stored message by its id
+func getStoredMessage(userid int, id string) (*events.Message, bool) {
+	stored, found := messagecache.Get(fmt.Sprintf("%d:%s", userid, id))
+	if !found {
+		return nil, false
+	}
+	return stored.(*events.Message), true
+}
+
+// Drops the oldest tenth of the stored messages, the ones closest to expiring
+func trimMessageCache() {
+	items := messagecache.Items()
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return items[keys[i]].Expiration < items[keys[j]].Expiration
+	})
+	for _, key := range keys[:len(keys)/10+1] {
+		messagecache.Delete(key)
+	}
+}
+
+// Removes a stored message, for example after it was revoked
+func deleteStoredMessage(userid int, id string) {
+	messagecache.Delete(fmt.Sprintf("%d:%s", userid, id))
+}
+
+// Stores a message sent through the API so it can be quoted, edited or forwarded later
+func storeSentMessage(userid int, recipient types.JID, resp whatsmeow.SendResponse, msg *waE2E.Message) {
+	ownJID := clientPointer[userid].Store.ID
+	if ownJID == nil {
+		return
+	}
+	storeMessage(userid, &events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{
+				Chat:     recipient,
+				Sender:   ownJID.ToNonAD(),
+				IsFromMe: true,
+				IsGroup:  recipient.Server == types.GroupServer,
+			},
+			ID:        resp.ID,
+			Timestamp: resp.Timestamp,
+		},
+		Message: msg,
+	})
+}
+
+// Builds the ContextInfo of an outgoing message from the one supplied by the caller. When replying, the participant
+// and the quoted message are taken from the message store if the caller did not supply them
+func buildContextInfo(userid int, info *waE2E.ContextInfo) (*waE2E.ContextInfo, error) {
+	if info.GetStanzaID() == "" {
+		if info.GetParticipant() != "" || info.GetQuotedMessage() != nil {
+			return nil, errors.New("missing StanzaId in ContextInfo")
+		}
+		if len(info.GetMentionedJID()) == 0 {
+			return nil, nil
+		}
+		return &waE2E.ContextInfo{MentionedJID: info.GetMentionedJID()}, nil
+	}
+
+	contextInfo := &waE2E.ContextInfo{
+		MentionedJID: info.GetMentionedJID(),
+		StanzaID:     proto.String(info.GetStanzaID()),
+	}
+	if info.GetParticipant() != "" {
+		contextInfo.Participant = proto.String(info.GetParticipant())
+	}
+
+	quoted := info.GetQuotedMessage()
+	if stored, found := getStoredMessage(userid, info.GetStanzaID()); found {
+		if contextInfo.Participant == nil {
+			contextInfo.Participant = proto.String(stored.Info.Sender.ToNonAD().String())
+		}
+		if quoted == nil {
+			quoted = stored.Message
+		}
+	}
+
+	if quoted == nil {
+		log.Warn().Str("id", info.GetStanzaID()).Msg("Quoted message not found in store, reply will show an empty quote")
+		contextInfo.QuotedMessage = &waE2E.Message{Conversation: proto.String("")}
+		return contextInfo, nil
+	}
+
+	// The quote only carries the message itself, not the reply or mentions it was sent with
+	contextInfo.QuotedMessage = proto.Clone(quoted).(*waE2E.Message)
+	contextInfo.QuotedMessage.MessageContextInfo = nil
+	clearContextInfo(contextInfo.QuotedMessage)
+	return contextInfo, nil
+}
+
+var mentionToken = regexp.MustCompile(`(?:^|[^\w@])@(\d{5,16})\b`)
+
+// Adds the mentioned users to the ContextInfo of an outgoing message: the ones given explicitly, the @<number> tokens
+// found in the text and, when mentionAll is set, every participant of the group
+func addMentions(userid int, contextInfo *waE2E.ContextInfo, recipient types.JID, text string, mentions []string, mentionAll bool) (*waE2E.ContextInfo, int, error) {
+	var jids []string
+	seen := make(map[string]bool)
+	add := func(jid types.JID) {
+		user := jid.ToNonAD().String()
+		if !seen[user] {
+			seen[user] = true
+			jids = append(jids, user)
+		}
+	}
+
+	for _, mention := range contextInfo.GetMentionedJID() {
+		if jid, ok := parseJID(mention); ok {
+			add(jid)
+		}
+	}
+	for _, mention := range mentions {
+		jid, ok := parseJID(mention)
+		if !ok {
+			return nil, http.StatusBadRequest, fmt.Errorf("could not parse mention %s", mention)
+		}
+		add(jid)
+	}
+	for _, match := range mentionToken.FindAllStringSubmatch(text, -1) {
+		add(types.NewJID(match[1], types.DefaultUserServer))
+	}
+
+	if mentionAll {
+		if recipient.Server != types.GroupServer {
+			return nil, http.StatusBadRequest, errors.New("mentioning all participants is only possible in groups")
+		}
+		groupInfo, err := clientPointer[userid].GetGroupInfo(recipient)
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("failed to get group info: %v", err)
+		}
+		ownJID := clientPointer[userid].Store.ID
+		for _, participant := range groupInfo.Participants {
+			if ownJID != nil && participant.JID.User == ownJID.User {
+				continue
+			}
+			add(participant.JID)
+		}
+	}
+
+	if len(jids) == 0 {
+		return contextInfo, http.StatusOK, nil
+	}
+	if contextInfo == nil {
+		contextInfo = &waE2E.ContextInfo{}
+	}
+	contextInfo.MentionedJID = jids
+	return contextInfo, http.StatusOK, nil
+}
+
+// Attaches a ContextInfo to whatever kind of message is being sent. Plain conversation messages cannot carry one,
+// so they are turned into extended text messages
+func setContextInfo(msg *waE2E.Message, contextInfo *waE2E.ContextInfo) {
+	if contextInfo == nil {
+		return
+	}
+	switch {
+	case msg.Conversation != nil:
+		msg.ExtendedTextMessage = &waE2E.ExtendedTextMessage{Text: msg.Conversation, ContextInfo: contextInfo}
+		msg.Conversation = nil
+	case msg.ExtendedTextMessage != nil:
+		msg.ExtendedTextMessage.ContextInfo = contextInfo
+	case msg.ImageMessage != nil:
+		msg.ImageMessage.ContextInfo = contextInfo
+	case msg.VideoMessage != nil:
+		msg.VideoMessage.ContextInfo = contextInfo
+	case msg.AudioMessage != nil:
+		msg.AudioMessage.ContextInfo = contextInfo
+	case msg.DocumentMessage != nil:
+		msg.DocumentMessage.ContextInfo = contextInfo
+	case msg.StickerMessage != nil:
+		msg.StickerMessage.ContextInfo = contextInfo
+	case msg.LocationMessage != nil:
+		msg.LocationMessage.ContextInfo = contextInfo
+	case msg.LiveLocationMessage != nil:
+		msg.LiveLocationMessage.ContextInfo = contextInfo
+	case msg.ContactMessage != nil:
+		msg.ContactMessage.ContextInfo = contextInfo
+	case msg.ContactsArrayMessage != nil:
+		msg.ContactsArrayMessage.ContextInfo = contextInfo
+	case msg.ButtonsMessage != nil:
+		msg.ButtonsMessage.ContextInfo = contextInfo
+	case msg.ListMessage != nil:
+		msg.ListMessage.ContextInfo = contextInfo
+	case msg.PollCreationMessage != nil:
+		msg.PollCreationMessage.ContextInfo = contextInfo
+	case msg.InteractiveMessage != nil:
+		msg.InteractiveMessage.ContextInfo = contextInfo
+	case msg.ViewOnceMessage.GetMessage() != nil:
+		setContextInfo(msg.ViewOnceMessage.Message, contextInfo)
+	}
+}
+
+// Removes the ContextInfo from a message of any kind, including the one wrapped in a view once message
+func clearContextInfo(msg *waE2E.Message) {
+	if msg.ExtendedTextMessage != nil {
+		msg.ExtendedTextMessage.ContextInfo = nil
+	}
+	if msg.ImageMessage != nil {
+		msg.ImageMessage.ContextInfo = nil
+	}
+	if msg.VideoMessage != nil {
+		msg.VideoMessage.ContextInfo = nil
+	}
+	if msg.AudioMessage != nil {
+		msg.AudioMessage.ContextInfo = nil
+	}
+	if msg.DocumentMessage != nil {
+		msg.DocumentMessage.ContextInfo = nil
+	}
+	if msg.StickerMessage != nil {
+		msg.StickerMessage.ContextInfo = nil
+	}
+	if msg.LocationMessage != nil {
+		msg.LocationMessage.ContextInfo = nil
+	}
+	if msg.LiveLocationMessage != nil {
+		msg.LiveLocationMessage.ContextInfo = nil
+	}
+	if msg.ContactMessage != nil {
+		msg.ContactMessage.ContextInfo = nil
+	}
+	if msg.ContactsArrayMessage != nil {
+		msg.ContactsArrayMessage.ContextInfo = nil
+	}
+	if msg.ButtonsMessage != nil {
+		msg.ButtonsMessage.ContextInfo = nil
+	}
+	if msg.ListMessage != nil {
+		msg.ListMessage.ContextInfo = nil
+	}
+	if msg.PollCreationMessage != nil {
+		msg.PollCreationMessage.ContextInfo = nil
+	}
+	if msg.InteractiveMessage != nil {
+		msg.InteractiveMessage.ContextInfo = nil
+	}
+	if msg.ViewOnceMessage.GetMessage() != nil {
+		clearContextInfo(msg.ViewOnceMessage.Message)
+	}
+}
+
+// Builds the new content of an edited message from the original one, replacing its text or caption while keeping
+// its type, media and ContextInfo
+func editedContent(original *waE2E.Message, body string) (*waE2E.Message, error) {
+	switch {
+	case original.GetExtendedTextMessage() != nil:
+		return &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+			Text:        proto.String(body),
+			ContextInfo: original.GetExtendedTextMessage().GetContextInfo(),
+		}}, nil
+	case original.GetImageMessage() != nil:
+		edited := proto.Clone(original.GetImageMessage()).(*waE2E.ImageMessage)
+		edited.Caption = proto.String(body)
+		return &waE2E.Message{ImageMessage: edited}, nil
+	case original.GetVideoMessage() != nil:
+		edited := proto.Clone(original.GetVideoMessage()).(*waE2E.VideoMessage)
+		edited.Caption = proto.String(body)
+		return &waE2E.Message{VideoMessage: edited}, nil
+	case original.GetDocumentMessage() != nil:
+		edited := proto.Clone(original.GetDocumentMessage()).(*waE2E.DocumentMessage)
+		edited.Caption = proto.String(body)
+		return &waE2E.Message{DocumentMessage: edited}, nil
+	case original.GetDocumentWithCaptionMessage().GetMessage() != nil:
+		edited, err := editedContent(original.GetDocumentWithCaptionMessage().GetMessage(), body)
+		if err != nil {
+			return nil, err
+		}
+		return &waE2E.Message{DocumentWithCaptionMessage: &waE2E.FutureProofMessage{Message: edited}}, nil
+	case original.Conversation != nil:
+		return &waE2E.Message{Conversation: proto.String(body)}, nil
+	}
+	return nil, errors.New("only text messages and image, video or document captions can be edited")
+}
+
+// Returns the ContextInfo of a message, whatever its kind
+func getContextInfo(msg *waE2E.Message) *waE2E.ContextInfo {
+	switch {
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetContextInfo()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetContextInfo()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetContextInfo()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage().GetContextInfo()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetContextInfo()
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage().GetContextInfo()
+	case msg.GetLocationMessage() != nil:
+		return msg.GetLocationMessage().GetContextInfo()
+	case msg.GetLiveLocationMessage() != nil:
+		return msg.GetLiveLocationMessage().GetContextInfo()
+	case msg.GetContactMessage() != nil:
+		return msg.GetContactMessage().GetContextInfo()
+	case msg.GetContactsArrayMessage() != nil:
+		return msg.GetContactsArrayMessage().GetContextInfo()
+	case msg.GetButtonsMessage() != nil:
+		return msg.GetButtonsMessage().GetContextInfo()
+	case msg.GetListMessage() != nil:
+		return msg.GetListMessage().GetContextInfo()
+	case msg.GetPollCreationMessage() != nil:
+		return msg.GetPollCreationMessage().GetContextInfo()
+	case msg.GetInteractiveMessage() != nil:
+		return msg.GetInteractiveMessage().GetContextInfo()
+	case msg.GetViewOnceMessage().GetMessage() != nil:
+		return getContextInfo(msg.GetViewOnceMessage().GetMessage())
+	}
+	return nil
+}
+
+// Media keys stop working once WhatsApp removes the upload from its servers, after about two weeks. The age is taken
+// from the media key timestamp, or from when the message was sent when the media does not carry one
+func mediaKeyExpired(media whatsmeow.DownloadableMessage, sent time.Time) bool {
+	if timestamped, ok := media.(interface{ GetMediaKeyTimestamp() int64 }); ok && timestamped.GetMediaKeyTimestamp() > 0 {
+		sent = time.Unix(timestamped.GetMediaKeyTimestamp(), 0)
+	}
+	return !sent.IsZero() && time.Since(sent) > 14*24*time.Hour
+}
+
+// Downloads the media of a message and uploads it again, replacing its keys in place
+func reuploadMedia(userid int, stored *events.Message, media whatsmeow.DownloadableMessage) error {
+	data, err := clientPointer[userid].Download(media)
+	if err != nil && stored != nil && (errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith404) || errors.Is(err, whatsmeow.ErrMediaDownloadFailedWith410)) {
+		data, err = retryMediaDownload(userid, stored, media)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download media: %v", err)
+	}
+
+	uploaded, err := clientPointer[userid].Upload(context.Background(), data, whatsmeow.GetMediaType(media))
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %v", err)
+	}
+
+	now := proto.Int64(time.Now().Unix())
+	switch m := media.(type) {
+	case *waE2E.ImageMessage:
+		m.URL, m.DirectPath, m.MediaKey, m.FileEncSHA256, m.FileSHA256, m.MediaKeyTimestamp = &uploaded.URL, &uploaded.DirectPath, uploaded.MediaKey, uploaded.FileEncSHA256, uploaded.FileSHA256, now
+	case *waE2E.VideoMessage:
+		m.URL, m.DirectPath, m.MediaKey, m.FileEncSHA256, m.FileSHA256, m.MediaKeyTimestamp = &uploaded.URL, &uploaded.DirectPath, uploaded.MediaKey, uploaded.FileEncSHA256, uploaded.FileSHA256, now
+	case *waE2E.AudioMessage:
+		m.URL, m.DirectPath, m.MediaKey, m.FileEncSHA256, m.FileSHA256, m.MediaKeyTimestamp = &uploaded.URL, &uploaded.DirectPath, uploaded.MediaKey, uploaded.FileEncSHA256, uploaded.FileSHA256, now
+	case *waE2E.DocumentMessage:
+		m.URL, m.DirectPath, m.MediaKey, m.FileEncSHA256, m.FileSHA256, m.MediaKeyTimestamp = &uploaded.URL, &uploaded.DirectPath, uploaded.MediaKey, uploaded.FileEncSHA256, uploaded.FileSHA256, now
+	case *waE2E.StickerMessage:
+		m.URL, m.DirectPath, m.MediaKey, m.FileEncSHA256, m.FileSHA256, m.MediaKeyTimestamp = &uploaded.URL, &uploaded.DirectPath, uploaded.MediaKey, uploaded.FileEncSHA256, uploaded.FileSHA256, now
+	default:
+		return errors.New("unsupported media type")
+	}
+	return nil
+}
+
+// Remembers the disappearing messages timer of a chat, in seconds
+func storeDisappearingTimer(userid int, chat types.JID, timer uint32) {
+	expirycache.Set(fmt.Sprintf("%d:%s", userid, chat.ToNonAD().String()), timer, cache.DefaultExpiration)
+}
+
+// Remembers the default disappearing messages timer of the account, used for new chats
+func storeDefaultDisappearingTimer(userid int, timer uint32) {
+	expirycache.Set(fmt.Sprintf("%d:default", userid), timer, cache.NoExpiration)
+}
+
+// Gets the disappearing messages timer of a chat. Groups seen for the first time are looked up, private chats
+// are assumed to use the default timer of the account until a message or setting change says otherwise
+func getDisappearingTimer(userid int, chat types.JID) uint32 {
+	if timer, found := expirycache.Get(fmt.Sprintf("%d:%s", userid, chat.ToNonAD().String())); found {
+		return timer.(uint32)
+	}
+	if chat.Server != types.GroupServer {
+		if timer, found := expirycache.Get(fmt.Sprintf("%d:default", userid)); found {
+			return timer.(uint32)
+		}
+		return 0
+	}
+
+	groupInfo, err := clientPointer[userid].GetGroupInfo(chat)
+	if err != nil {
+		log.Warn().Err(err).Str("group", chat.String()).Msg("Could not get group disappearing messages timer")
+		return 0
+	}
+	timer := uint32(0)
+	if groupInfo.IsEphemeral {
+		timer = groupInfo.DisappearingTimer
+	}
+	storeDisappearingTimer(userid, chat, timer)
+	return timer
+}
+
+// Makes an outgoing message disappear along with the rest of the chat, when it has a disappearing messages timer
+func applyDisappearingTimer(userid int, chat types.JID, msg *waE2E.Message) {
+	timer := getDisappearingTimer(userid, chat)
+	if timer == 0 {
+		return
+	}
+	contextInfo := getContextInfo(msg)
+	if contextInfo == nil {
+		contextInfo = &waE2E.ContextInfo{}
+		setContextInfo(msg, contextInfo)
+	}
+	contextInfo.Expiration = proto.Uint32(timer)
+}
+
+// Returns the poll creation part of a message, whichever version it uses
+func getPollCreation(msg *waE2E.Message) *waE2E.PollCreationMessage {
+	switch {
+	case msg.GetPollCreationMessage() != nil:
+		return msg.GetPollCreationMessage()
+	case msg.GetPollCreationMessageV2() != nil:
+		return msg.GetPollCreationMessageV2()
+	case msg.GetPollCreationMessageV3() != nil:
+		return msg.GetPollCreationMessageV3()
+	}
+	return nil
+}
+
+// Keeps the option names of a poll, votes only carry their hashes
+func storePoll(userid int, id string, poll *waE2E.PollCreationMessage) {
+	options := make([]string, len(poll.GetOptions()))
+	for i, option := range poll.GetOptions() {
+		options[i] = option.GetOptionName()
+	}
+	pollcache.Set(fmt.Sprintf("%d:%s", userid, id), options, cache.DefaultExpiration)
+}
+
+// Translates the option hashes of a poll vote back into option names. Hashes of unknown polls are returned hex encoded
+func getPollVoteOptions(userid int, pollId string, hashes [][]byte) ([]string, bool) {
+	options := make(map[string]string)
+	cached, found := pollcache.Get(fmt.Sprintf("%d:%s", userid, pollId))
+	if found {
+		for _, option := range cached.([]string) {
+			options[string(whatsmeow.HashPollOptions([]string{option})[0])] = option
+		}
+	}
+
+	selected := make([]string, len(hashes))
+	for i, hash := range hashes {
+		if option, ok := options[string(hash)]; ok {
+			selected[i] = option
+		} else {
+			selected[i] = fmt.Sprintf("%x", hash)
+		}
+	}
+	return selected, found
+}
+
+// Builds a native flow button, the parameters are sent JSON encoded
+func nativeFlowButton(name string, params map[string]interface{}) *waE2E.InteractiveMessage_NativeFlowMessage_NativeFlowButton {
+	paramsJson, _ := json.Marshal(params)
+	return &waE2E.InteractiveMessage_NativeFlowMessage_NativeFlowButton{
+		Name:             proto.String(name),
+		ButtonParamsJSON: proto.String(string(paramsJson)),
+	}
+}
+
+// Builds a native flow interactive message, wrapped in the view once container clients expect for them
+func buildInteractiveMessage(title string, body string, footer string, buttons []*waE2E.InteractiveMessage_NativeFlowMessage_NativeFlowButton) *waE2E.Message {
+	interactive := &waE2E.InteractiveMessage{
+		Body: &waE2E.InteractiveMessage_Body{Text: proto.String(body)},
+		InteractiveMessage: &waE2E.InteractiveMessage_NativeFlowMessage_{
+			NativeFlowMessage: &waE2E.InteractiveMessage_NativeFlowMessage{
+				Buttons:           buttons,
+				MessageParamsJSON: proto.String("{}"),
+				MessageVersion:    proto.Int32(1),
+			},
+		},
+	}
+	if title != "" {
+		interactive.Header = &waE2E.InteractiveMessage_Header{Title: proto.String(title), HasMediaAttachment: proto.Bool(false)}
+	}
+	if footer != "" {
+		interactive.Footer = &waE2E.InteractiveMessage_Footer{Text: proto.String(footer)}
+	}
+
+	return &waE2E.Message{
+		ViewOnceMessage: &waE2E.FutureProofMessage{
+			Message: &waE2E.Message{
+				MessageContextInfo: &waE2E.MessageContextInfo{
+					DeviceListMetadata:        &waE2E.DeviceListMetadata{},
+					DeviceListMetadataVersion: proto.Int32(2),
+				},
+				InteractiveMessage: interactive,
+			},
+		},
+	}
+}
+
+// Lists the options of an interactive message after its body, so clients that only show its text still show them
+func interactiveFallback(body string, options []string) string {
+	if body == "" {
+		return strings.Join(options, "\n")
+	}
+	return body + "\n\n" + strings.Join(options, "\n")
+}
+
+// Sends an interactive message and stores it
+func sendInteractive(userid int, recipient types.JID, msgid string, msg *waE2E.Message) (whatsmeow.SendResponse, error) {
+	applyDisappearingTimer(userid, recipient, msg)
+	resp, err := clientPointer[userid].SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+	if err != nil {
+		return resp, err
+	}
+	storeSentMessage(userid, recipient, resp, msg)
+	return resp, nil
+}
+
+// Extracts the option selected in a reply to buttons, lists or native flow messages
+func parseInteractiveReply(msg *waE2E.Message) map[string]interface{} {
+	reply := make(map[string]interface{})
+	var contextInfo *waE2E.ContextInfo
+
+	switch {
+	case msg.GetButtonsResponseMessage() != nil:
+		response := msg.GetButtonsResponseMessage()
+		reply["Type"] = "button"
+		reply["Id"] = response.GetSelectedButtonID()
+		reply["Text"] = response.GetSelectedDisplayText()
+		contextInfo = response.GetContextInfo()
+	case msg.GetListResponseMessage() != nil:
+		response := msg.GetListResponseMessage()
+		reply["Type"] = "list"
+		reply["Id"] = response.GetSingleSelectReply().GetSelectedRowID()
+		reply["Text"] = response.GetTitle()
+		contextInfo = response.GetContextInfo()
+	case msg.GetTemplateButtonReplyMessage() != nil:
+		response := msg.GetTemplateButtonReplyMessage()
+		reply["Type"] = "template"
+		reply["Id"] = response.GetSelectedID()
+		reply["Text"] = response.GetSelectedDisplayText()
+		contextInfo = response.GetContextInfo()
+	case msg.GetInteractiveResponseMessage() != nil:
+		response := msg.GetInteractiveResponseMessage()
+		nativeFlow := response.GetNativeFlowResponseMessage()
+		params := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(nativeFlow.GetParamsJSON()), &params); err != nil {
+			log.Warn().Err(err).Msg("Could not parse native flow response parameters")
+		}
+		reply["Type"] = nativeFlow.GetName()
+		reply["Id"] = params["id"]
+		reply["Text"] = response.GetBody().GetText()
+		reply["Params"] = params
+		contextInfo = response.GetContextInfo()
+	default:
+		return nil
+	}
+
+	reply["MessageId"] = contextInfo.GetStanzaID()
+	return reply
+}
+
+// A phone number of a shared contact. WaId is the WhatsApp id of the number, it defaults to its digits
+type contactPhone struct {
+	Number string
+	Type   string
+	WaId   string
+}
+
+type contactEmail struct {
+	Address string
+	Type    string
+}
+
+// A contact as sent or received in contact messages
+type contactCard struct {
+	FullName     string
+	FirstName    string
+	LastName     string
+	Organization string
+	Url          string
+	Phones       []contactPhone
+	Emails       []contactEmail
+}
+
+var vcardEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`)
+var vcardUnescaper = strings.NewReplacer(`\\`, `\`, `\,`, ",", `\;`, ";", `\n`, "\n", `\N`, "\n")
+
+// Types accepted for phones and emails, anything else could break out of the property parameters
+var phoneTypes = map[string]bool{"CELL": true, "MAIN": true, "IPHONE": true, "HOME": true, "WORK": true, "FAX": true, "PAGER": true, "OTHER": true}
+var emailTypes = map[string]bool{"HOME": true, "WORK": true, "OTHER": true}
+
+var waidDigits = regexp.MustCompile(`^\d{5,16}$`)
+
+// Checks the fields of a contact that are written into the vCard without escaping
+func (contact contactCard) validate() error {
+	if contact.displayName() == "" {
+		return errors.New("missing name in contact")
+	}
+	if len(contact.Phones) == 0 {
+		return errors.New("missing phones in contact")
+	}
+	for _, phone := range contact.Phones {
+		if phone.Type != "" && !phoneTypes[strings.ToUpper(phone.Type)] {
+			return fmt.Errorf("phone type must be one of cell, main, iphone, home, work, fax, pager or other, got %q", phone.Type)
+		}
+		if phone.WaId != "" && !waidDigits.MatchString(phone.WaId) {
+			return fmt.Errorf("waid must be the digits of a phone number, got %q", phone.WaId)
+		}
+	}
+	for _, email := range contact.Emails {
+		if email.Type != "" && !emailTypes[strings.ToUpper(email.Type)] {
+			return fmt.Errorf("email type must be one of home, work or other, got %q", email.Type)
+		}
+	}
+	if strings.ContainsAny(contact.Url, " \t\r\n") {
+		return errors.New("url cannot contain spaces or line breaks")
+	}
+	return nil
+}
+
+// Returns the display name of a contact, built from its first and last names when no full name is given
+func (contact contactCard) displayName() string {
+	if contact.FullName != "" {
+		return contact.FullName
+	}
+	return strings.TrimSpace(contact.FirstName + " " + contact.LastName)
+}
+
+// Generates a vCard 3.0 for a contact, which must have been validated
+func (contact contactCard) vcard() string {
+	var b strings.Builder
+	b.WriteString("BEGIN:VCARD\nVERSION:3.0\n")
+	fmt.Fprintf(&b, "N:%s;%s;;;\n", vcardEscaper.Replace(contact.LastName), vcardEscaper.Replace(contact.FirstName))
+	fmt.Fprintf(&b, "FN:%s\n", vcardEscaper.Replace(contact.displayName()))
+	if contact.Organization != "" {
+		fmt.Fprintf(&b, "ORG:%s\n", vcardEscaper.Replace(contact.Organization))
+	}
+	for _, phone := range contact.Phones {
+		phoneType := strings.ToUpper(phone.Type)
+		if phoneType == "" {
+			phoneType = "CELL"
+		}
+		waid := phone.WaId
+		if waid == "" {
+			waid = strings.Map(func(r rune) rune {
+				if r >= '0' && r <= '9' {
+					return r
+				}
+				return -1
+			}, phone.Number)
+		}
+		fmt.Fprintf(&b, "TEL;type=%s;type=VOICE;waid=%s:%s\n", phoneType, waid, vcardEscaper.Replace(phone.Number))
+	}
+	for _, email := range contact.Emails {
+		emailType := strings.ToUpper(email.Type)
+		if emailType == "" {
+			emailType = "HOME"
+		}
+		fmt.Fprintf(&b, "EMAIL;type=INTERNET;type=%s:%s\n", emailType, vcardEscaper.Replace(email.Address))
+	}
+	if contact.Url != "" {
+		// URIs are written as they are, RFC 6350 does not escape commas in them
+		fmt.Fprintf(&b, "URL:%s\n", contact.Url)
+	}
+	b.WriteString("END:VCARD")
+	return b.String()
+}
+
+// Parses a vCard back into a contact, ignoring the properties it does not know about
+func parseVcard(vcard string) contactCard {
+	var contact contactCard
+
+	// Long lines are folded by starting the continuation lines with a space or a tab
+	vcard = strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(vcard)
+
+	for _, line := range strings.Split(vcard, "\n") {
+		line = strings.TrimRight(line, "\r")
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		params := strings.Split(name, ";")
+		property := strings.ToUpper(params[0])
+		if _, ungrouped, grouped := strings.Cut(property, "."); grouped {
+			property = ungrouped
+		}
+
+		paramType := ""
+		waid := ""
+		for _, param := range params[1:] {
+			key, paramValue, _ := strings.Cut(param, "=")
+			switch strings.ToLower(key) {
+			case "type":
+				// Types can be repeated or given as a comma separated list, the first meaningful one is kept
+				for _, t := range strings.Split(strings.Trim(paramValue, `"`), ",") {
+					if t = strings.ToUpper(t); t != "VOICE" && t != "INTERNET" && t != "PREF" && t != "" && paramType == "" {
+						paramType = t
+					}
+				}
+			case "waid":
+				waid = paramValue
+			}
+		}
+
+		switch property {
+		case "FN":
+			contact.FullName = vcardUnescaper.Replace(value)
+		case "N":
+			parts := splitVcardValue(value)
+			contact.LastName = vcardUnescaper.Replace(parts[0])
+			if len(parts) > 1 {
+				contact.FirstName = vcardUnescaper.Replace(parts[1])
+			}
+		case "ORG":
+			contact.Organization = vcardUnescaper.Replace(strings.TrimRight(value, ";"))
+		case "TEL":
+			contact.Phones = append(contact.Phones, contactPhone{Number: vcardUnescaper.Replace(value), Type: paramType, WaId: waid})
+		case "EMAIL":
+			contact.Emails = append(contact.Emails, contactEmail{Address: vcardUnescaper.Replace(value), Type: paramType})
+		case "URL":
+			contact.Url = value
+		}
+	}
+	return contact
+}
+
+// Splits a structured vCard value on the semicolons that are not escaped
+func splitVcardValue(value string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case ';':
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
+
+// Returns the contacts shared in a message, if any
+func getSharedContacts(msg *waE2E.Message) []contactCard {
+	var contacts []contactCard
+	if msg.GetContactMessage() != nil {
+		contacts = append(contacts, parseVcard(msg.GetContactMessage().GetVcard()))
+	}
+	for _, contact := range msg.GetContactsArrayMessage().GetContacts() {
+		contacts = append(contacts, parseVcard(contact.GetVcard()))
+	}
+	return contacts
+}
+
+// Maximum time a live location can be shared, as allowed by WhatsApp clients
+const maxLiveLocationDuration = 8 * time.Hour
+
+// A live location being shared. Updates reuse the id of the first message with an increasing sequence number, and a
+// final update is sent when it is stopped or its duration is over
+type liveLocation struct {
+	sync.Mutex
+	Recipient   types.JID
+	Caption     string
+	ContextInfo *waE2E.ContextInfo
+	Started     time.Time
+	Duration    time.Duration
+	Sequence    int64
+	Latitude    float64
+	Longitude   float64
+	Accuracy    uint32
+	Speed       float32
+	Heading     uint32
+	stopped     bool
+	timer       *time.Timer
+}
+
+// Starts tracking a live location, it is stopped automatically once its duration is over
+func storeLiveLocation(userid int, id string, location *liveLocation) {
+	location.Lock()
+	defer location.Unlock()
+	livelocations.Set(fmt.Sprintf("%d:%s", userid, id), location, location.Duration+time.Minute)
+	location.timer = time.AfterFunc(location.Duration, func() {
+		if _, err := stopLiveLocation(userid, id, location); err != nil {
+			log.Warn().Err(err).Str("id", id).Msg("Failed to send final live location message")
+		}
+	})
+}
+
+// Gets a live location that is still being shared
+func getLiveLocation(userid int, id string) (*liveLocation, bool) {
+	location, found := livelocations.Get(fmt.Sprintf("%d:%s", userid, id))
+	if !found {
+		return nil, false
+	}
+	return location.(*liveLocation), true
+}
+
+// Stops tracking a live location
+func deleteLiveLocation(userid int, id string) {
+	livelocations.Delete(fmt.Sprintf("%d:%s", userid, id))
+}
+
+// Sends a message of a live location under the id of the one that started it, so recipients update it in place
+func sendLiveLocation(userid int, id string, location *liveLocation, msg *waE2E.Message) (whatsmeow.SendResponse, error) {
+	if clientPointer[userid] == nil {
+		return whatsmeow.SendResponse{}, errors.New("no session")
+	}
+	applyDisappearingTimer(userid, location.Recipient, msg)
+	resp, err := clientPointer[userid].SendMessage(context.Background(), location.Recipient, msg, whatsmeow.SendRequestExtra{ID: id})
+	if err != nil {
+		return resp, err
+	}
+	storeSentMessage(userid, location.Recipient, resp, msg)
+	return resp, nil
+}
+
+// Stops sharing a live location, sending its final message
+func stopLiveLocation(userid int, id string, location *liveLocation) (whatsmeow.SendResponse, error) {
+	msg, err := location.finalMessage()
+	if err != nil {
+		return whatsmeow.SendResponse{}, err
+	}
+	deleteLiveLocation(userid, id)
+	return sendLiveLocation(userid, id, location, msg)
+}
+
+// Builds the next message of a live location, remembering its new position
+func (location *liveLocation) nextMessage(latitude float64, longitude float64, accuracy uint32, speed float32, heading uint32) (*waE2E.Message, error) {
+	location.Lock()
+	defer location.Unlock()
+	if location.stopped {
+		return nil, errors.New("live location was already stopped")
+	}
+	location.Latitude, location.Longitude, location.Accuracy, location.Speed, location.Heading = latitude, longitude, accuracy, speed, heading
+	return location.message(uint32(time.Since(location.Started).Seconds())), nil
+}
+
+// Builds the final message of a live location, at its last position and with the time offset of the end of its
+// duration, so recipients no longer show it as live
+func (location *liveLocation) finalMessage() (*waE2E.Message, error) {
+	location.Lock()
+	defer location.Unlock()
+	if location.stopped {
+		return nil, errors.New("live location was already stopped")
+	}
+	location.stopped = true
+	if location.timer != nil {
+		location.timer.Stop()
+	}
+	return location.message(uint32(location.Duration.Seconds())), nil
+}
+
+// Builds a message with the current state of a live location, the caller holds the lock
+func (location *liveLocation) message(offset uint32) *waE2E.Message {
+	location.Sequence++
+	msg := &waE2E.LiveLocationMessage{
+		DegreesLatitude:  proto.Float64(location.Latitude),
+		DegreesLongitude: proto.Float64(location.Longitude),
+		Caption:          proto.String(location.Caption),
+		SequenceNumber:   proto.Int64(location.Sequence),
+		TimeOffset:       proto.Uint32(offset),
+	}
+	if location.Accuracy > 0 {
+		msg.AccuracyInMeters = proto.Uint32(location.Accuracy)
+	}
+	if location.Speed > 0 {
+		msg.SpeedInMps = proto.Float32(location.Speed)
+	}
+	if location.Heading > 0 {
+		msg.DegreesClockwiseFromMagneticNorth = proto.Uint32(location.Heading)
+	}
+	if location.ContextInfo != nil {
+		msg.ContextInfo = proto.Clone(location.ContextInfo).(*waE2E.ContextInfo)
+	}
+	return &waE2E.Message{LiveLocationMessage: msg}
+}
+
+// Parses a color given as #RRGGBB or #AARRGGBB into the ARGB value used by text statuses
+func parseArgbColor(text string) (uint32, error) {
+	hex := strings.TrimPrefix(text, "#")
+	if len(hex) == 6 {
+		hex = "FF" + hex
+	}
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 8 || err != nil {
+		return 0, fmt.Errorf("invalid color %q, expected #RRGGBB or #AARRGGBB", text)
+	}
+	return uint32(value), nil
+}
+
+// Gets the font of a text status by its name, such as SYSTEM, FB_SCRIPT or COURIERPRIME_BOLD
+func parseStatusFont(font string) (waE2E.ExtendedTextMessage_FontType, error) {
+	value, ok := waE2E.ExtendedTextMessage_FontType_value[strings.ToUpper(font)]
+	if !ok {
+		return 0, fmt.Errorf("unknown font %q", font)
+	}
+	return waE2E.ExtendedTextMessage_FontType(value), nil
+}
+
+// Returns the downloadable part of a message, if any
+func getDownloadable(msg *waE2E.Message) whatsmeow.DownloadableMessage {
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage()
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage()
+	case msg.GetDocumentWithCaptionMessage().GetMessage().GetDocumentMessage() != nil:
+		return msg.GetDocumentWithCaptionMessage().GetMessage().GetDocumentMessage()
+	}
+	return nil
+}
+
+var mediaRetryChannels = make(map[string]chan *events.MediaRetry)
+var mediaRetryLock sync.Mutex
+
+// Delivers a media retry notification to the download waiting for it
+func deliverMediaRetry(userid int, evt *events.MediaRetry) {
+	key := fmt.Sprintf("%d:%s", userid, evt.MessageID)
+	mediaRetryLock.Lock()
+	ch, found := mediaRetryChannels[key]
+	mediaRetryLock.Unlock()
+	if found {
+		select {
+		case ch <- evt:
+		default:
+		}
+	}
+}
+
+// Asks the sender's phone to re-upload expired media and downloads it from the new path
+func retryMediaDownload(userid int, evt *events.Message, media whatsmeow.DownloadableMessage) ([]byte, error) {
+	key := fmt.Sprintf("%d:%s", userid, evt.Info.ID)
+	ch := make(chan *events.MediaRetry, 1)
+	mediaRetryLock.Lock()
+	mediaRetryChannels[key] = ch
+	mediaRetryLock.Unlock()
+	defer func() {
+		mediaRetryLock.Lock()
+		delete(mediaRetryChannels, key)
+		mediaRetryLock.Unlock()
+	}()
+
+	err := clientPointer[userid].SendMediaRetryReceipt(&evt.Info, media.GetMediaKey())
+	if err != nil {
+		return nil, fmt.Errorf("failed to send media retry receipt: %w", err)
+	}
+
+	var retry *events.MediaRetry
+	select {
+	case retry = <-ch:
+	case <-time.After(30 * time.Second):
+		return nil, errors.New("timed out waiting for media re-upload")
+	}
+
+	notif, err := whatsmeow.DecryptMediaRetryNotification(retry, media.GetMediaKey())
+	if err != nil {
+		return nil, err
+	}
+	if notif.GetResult() != waProto.MediaRetryNotification_SUCCESS {
+		return nil, fmt.Errorf("media re-upload failed: %s", notif.GetResult())
+	}
+
+	fileLength := -1
+	if sized, ok := media.(interface{ GetFileLength() uint64 }); ok {
+		fileLength = int(sized.GetFileLength())
+	}
+	mediaType := whatsmeow.GetMediaType(media)
+	return clientPointer[userid].DownloadMediaWithPath(notif.GetDirectPath(), media.GetFileEncSHA256(), media.GetFileSHA256(), media.GetMediaKey(), fileLength, mediaType, "")
+}
+
+// Maximum size accepted for media sent through the API, either uploaded or fetched from a url
+const maxMediaSize = 100 << 20
+
+// Most redirects followed when fetching remote media
+const maxMediaRedirects = 5
+
+// Client used to fetch remote media. Callers choose the url, so connections to loopback, private, link-local and
+// similar addresses are refused when dialing, after name resolution, which also covers DNS rebinding and redirects.
+// Proxies from the environment are not used, as they would hide the final address
+var mediaHttpClient = &http.Client{
+	Timeout: 60 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, conn syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+					return fmt.Errorf("address %s is not allowed", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxMediaRedirects {
+			return errors.New("too many redirects")
+		}
+		return checkMediaUrl(req.URL)
+	},
+}
+
+// Carrier-grade NAT range, which net.IP does not consider private
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Tells if an address can be reached on the public internet
+func publicAddress(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+// Checks that a remote media url is http(s) and that its host does not point to an internal address. The address is
+// checked again when connecting, as it may resolve differently by then
+func checkMediaUrl(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("url should be a valid http or https address")
+	}
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("url should be a valid http or https address")
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		addrs, err := net.DefaultResolver.LookupIPAddr(context.Background(), host)
+		if err != nil {
+			return fmt.Errorf("could not resolve %s", host)
+		}
+		ips = ips[:0]
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if !publicAddress(ip) {
+			return fmt.Errorf("address of %s is not allowed", host)
+		}
+	}
+	return nil
+}
+
+// Media contents for a send request
+type mediaFile struct {
+	Data     []byte
+	Mimetype string
+	FileName string
+}
+
+// Checks if a mime type is acceptable for the given kind of media message
+func mediaTypeAllowed(kind string, mimetype string) bool {
+	switch kind {
+	case "image", "sticker":
+		return strings.HasPrefix(mimetype, "image/")
+	case "video":
+		return strings.HasPrefix(mimetype, "video/")
+	case "audio":
+		return strings.HasPrefix(mimetype, "audio/") || mimetype == "application/ogg"
+	}
+	return true
+}
+
+// Decodes a media send payload into t, either from a JSON body or from a multipart/form-data upload.
+// For multipart requests the file part named after field (or "file") is returned, if present.
+func decodeMediaPayload(w http.ResponseWriter, r *http.Request, t interface{}, field string) (*mediaFile, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxMediaSize+1<<20)
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != "multipart/form-data" {
+		err := json.NewDecoder(r.Body).Decode(t)
+		if err != nil {
+			return nil, errors.New("could not decode payload")
+		}
+		return nil, nil
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.New("could not decode multipart payload")
+	}
+
+	var upload *mediaFile
+	values := make(map[string]interface{})
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("could not decode multipart payload")
+		}
+
+		name := part.FormName()
+		if part.FileName() != "" {
+			if !strings.EqualFold(name, field) && !strings.EqualFold(name, "file") {
+				part.Close()
+				continue
+			}
+			data, err := io.ReadAll(io.LimitReader(part, maxMediaSize+1))
+			part.Close()
+			if err != nil {
+				return nil, errors.New("could not read uploaded file")
+			}
+			if len(data) > maxMediaSize {
+				return nil, fmt.Errorf("uploaded file exceeds the maximum size of %d bytes", maxMediaSize)
+			}
+			mimetype, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			upload = &mediaFile{Data: data, Mimetype: mimetype, FileName: part.FileName()}
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, 1<<20))
+		part.Close()
+		if err != nil {
+			return nil, errors.New("could not decode multipart payload")
+		}
+		// Nested objects, lists and flags are sent as JSON in form fields
+		trimmed := strings.TrimSpace(string(value))
+		if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") || trimmed == "true" || trimmed == "false") && json.Valid([]byte(trimmed)) {
+			values[name] = json.RawMessage(trimmed)
+		} else {
+			values[name] = string(value)
+		}
+	}
+
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return nil, errors.New("could not decode multipart payload")
+	}
+	err = json.Unmarshal(encoded, t)
+	if err != nil {
+		return nil, errors.New("could not decode multipart payload")
+	}
+	return upload, nil
+}
+
+// Downloads media from a remote url, enforcing size and content type limits
+func fetchMedia(mediaurl string, kind string) (*mediaFile, error) {
+	u, err := url.Parse(mediaurl)
+	if err != nil {
+		return nil, errors.New("url should be a valid http or https address")
+	}
+	err = checkMediaUrl(u)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := mediaHttpClient.Get(mediaurl)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch url: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch url: server returned %s", resp.Status)
+	}
+	if resp.ContentLength > maxMediaSize {
+		return nil, fmt.Errorf("remote file exceeds the maximum size of %d bytes", maxMediaSize)
+	}
+
+	// Generic content types are checked once the contents can be inspected
+	mimetype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mimetype != "" && mimetype != "application/octet-stream" && !mediaTypeAllowed(kind, mimetype) {
+		return nil, fmt.Errorf("remote file has content type %s, which is not valid for %s messages", mimetype, kind)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxMediaSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch url: %v", err)
+	}
+	if len(data) > maxMediaSize {
+		return nil, fmt.Errorf("remote file exceeds the maximum size of %d bytes", maxMediaSize)
+	}
+
+	filename := path.Base(resp.Request.URL.Path)
+	if filename == "/" || filename == "." {
+		filename = ""
+	}
+	media := &mediaFile{Data: data, Mimetype: mimetype, FileName: filename}
+	if len(media.Data) == 0 {
+		return nil, fmt.Errorf("%s is empty", kind)
+	}
+	if detected := detectMimetype(media); !mediaTypeAllowed(kind, detected) {
+		return nil, fmt.Errorf("remote file has content type %s, which is not valid for %s messages", detected, kind)
+	}
+	return media, nil
+}
+
+// Gets the media contents of a send request from a multipart upload, a base64 data URL or a remote url
+func loadMedia(upload *mediaFile, encoded string, mediaurl string, kind string) (*mediaFile, error) {
+	var media *mediaFile
+
+	if upload != nil {
+		media = upload
+	} else if encoded != "" {
+		if !strings.HasPrefix(encoded, "data:") {
+			return nil, fmt.Errorf("%s data should start with \"data:mime/type;base64,\"", kind)
+		}
+		dataURL, err := dataurl.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("could not decode base64 encoded data from payload")
+		}
+		media = &mediaFile{Data: dataURL.Data, Mimetype: dataURL.ContentType()}
+	} else if mediaurl != "" {
+		return fetchMedia(mediaurl, kind)
+	} else {
+		return nil, fmt.Errorf("missing %s in payload", kind)
+	}
+
+	if len(media.Data) == 0 {
+		return nil, fmt.Errorf("%s is empty", kind)
+	}
+	// Generic or missing types are replaced by what the file name or contents tell
+	if detected := detectMimetype(media); !mediaTypeAllowed(kind, detected) {
+		return nil, fmt.Errorf("content type %s is not valid for %s messages", detected, kind)
+	}
+	return media, nil
+}
+
+// Maximum width or height of generated JPEG thumbnails
+const thumbnailSize = 72
+
+// Mime types for common extensions that are missing from the standard library table
+var extensionMimetypes = map[string]string{
+	".doc":  "application/msword",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xls":  "application/vnd.ms-excel",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".ppt":  "application/vnd.ms-powerpoint",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	".odp":  "application/vnd.oasis.opendocument.presentation",
+	".csv":  "text/csv",
+	".txt":  "text/plain",
+	".zip":  "application/zip",
+	".rar":  "application/vnd.rar",
+	".7z":   "application/x-7z-compressed",
+	".apk":  "application/vnd.android.package-archive",
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".amr":  "audio/amr",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg",
+	".mp4":  "video/mp4",
+	".3gp":  "video/3gpp",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+}
+
+// Determines the mime type of media from its declared content type, its file name extension or its contents, in that order
+func detectMimetype(media *mediaFile) string {
+	if media.Mimetype != "" && media.Mimetype != "application/octet-stream" {
+		return media.Mimetype
+	}
+	if ext := strings.ToLower(filepath.Ext(media.FileName)); ext != "" {
+		if mimetype, ok := extensionMimetypes[ext]; ok {
+			return mimetype
+		}
+		if mimetype, _, err := mime.ParseMediaType(mime.TypeByExtension(ext)); err == nil {
+			return mimetype
+		}
+	}
+	mimetype, _, _ := mime.ParseMediaType(http.DetectContentType(media.Data))
+	return mimetype
+}
+
+// Largest image, in pixels, that is decoded. Decoders allocate for the size declared in the header, so a small file
+// can otherwise ask for gigabytes of memory
+const maxImagePixels = 40_000_000
+
+// Checks the dimensions declared by an image before it is decoded
+func checkImagePixels(data []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("could not decode image: %v", err)
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return fmt.Errorf("image of %dx%d pixels is larger than %d pixels", config.Width, config.Height, maxImagePixels)
+	}
+	return nil
+}
+
+// Decodes an image and returns its dimensions along with a small JPEG thumbnail
+func imageThumbnail(data []byte) ([]byte, uint32, uint32, error) {
+	if err := checkImagePixels(data); err != nil {
+		return nil, 0, 0, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, 0, 0, errors.New("image has no pixels")
+	}
+
+	tw, th := width, height
+	if tw > thumbnailSize || th > thumbnailSize {
+		if tw >= th {
+			tw, th = thumbnailSize, max(1, height*thumbnailSize/width)
+		} else {
+			tw, th = max(1, width*thumbnailSize/height), thumbnailSize
+		}
+	}
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, scaleImage(src, bounds, tw, th), &jpeg.Options{Quality: 75})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return buf.Bytes(), uint32(width), uint32(height), nil
+}
+
+// Scales the given area of an image to tw x th pixels, with a box filter sampling at most 4x4 source pixels for
+// each destination pixel
+func scaleImage(src image.Image, bounds image.Rectangle, tw int, th int) *image.RGBA {
+	width, height := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := bounds.Min.Y+y*height/th, bounds.Min.Y+(y+1)*height/th
+		ystep := max(1, (y1-y0)/4)
+		for x := 0; x < tw; x++ {
+			x0, x1 := bounds.Min.X+x*width/tw, bounds.Min.X+(x+1)*width/tw
+			xstep := max(1, (x1-x0)/4)
+			var r, g, b, a, n uint32
+			for sy := y0; sy < max(y1, y0+1); sy += ystep {
+				for sx := x0; sx < max(x1, x0+1); sx += xstep {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a, n = r+pr, g+pg, b+pb, a+pa, n+1
+				}
+			}
+			// JPEG has no alpha channel, so transparent areas are blended over white
+			bg := 0xffff - a/n
+			dst.Set(x, y, color.RGBA64{uint16(r/n + bg), uint16(g/n + bg), uint16(b/n + bg), 0xffff})
+		}
+	}
+	return dst
+}
+
+// Maximum width and height of profile pictures
+const profilePictureSize = 640
+
+// Turns an image into a profile picture: a JPEG cropped to a centered square, no bigger than WhatsApp accepts
+func profilePicture(data []byte) ([]byte, error) {
+	if err := checkImagePixels(data); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not decode image: %v", err)
+	}
+
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	if side == 0 {
+		return nil, errors.New("image has no pixels")
+	}
+	x0, y0 := bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2
+	size := min(side, profilePictureSize)
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, scaleImage(src, image.Rect(x0, y0, x0+side, y0+side), size, size), &jpeg.Options{Quality: 85})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Media already uploaded to WhatsApp servers, ready to be attached to messages
+type uploadedMedia struct {
+	whatsmeow.UploadResponse
+	MediaId   string
+	Mimetype  string
+	FileName  string
+	Thumbnail []byte
+	Width     uint32
+	Height    uint32
+	// Set when this request uploaded the file itself rather than reusing a handle
+	fresh bool
+}
+
+var mediaKinds = map[string]whatsmeow.MediaType{
+	"image":    whatsmeow.MediaImage,
+	"sticker":  whatsmeow.MediaImage,
+	"video":    whatsmeow.MediaVideo,
+	"audio":    whatsmeow.MediaAudio,
+	"document": whatsmeow.MediaDocument,
+}
+
+// Stickers are uploaded with the same keys as images, so their uploads are interchangeable
+func mediaKindPrefix(kind string) string {
+	if kind == "sticker" {
+		return "image"
+	}
+	return kind
+}
+
+// Gets the mime type, file name and, for images, the thumbnail and dimensions of media about to be uploaded
+func describeMedia(media *mediaFile, kind string) *uploadedMedia {
+	prepared := &uploadedMedia{Mimetype: detectMimetype(media), FileName: media.FileName}
+
+	if kind == "image" || kind == "document" && strings.HasPrefix(prepared.Mimetype, "image/") {
+		thumbnail, width, height, err := imageThumbnail(media.Data)
+		if err != nil {
+			log.Warn().Err(err).Msg("Could not generate image thumbnail")
+		} else {
+			prepared.Thumbnail = thumbnail
+			prepared.Width = width
+			prepared.Height = height
+		}
+	}
+	return prepared
+}
+
+// Uploads media for the given kind of message, reusing a previous upload of the same contents if there is one
+func prepareMedia(userid int, media *mediaFile, kind string) (*uploadedMedia, error) {
+	mediaType := mediaKinds[kind]
+	prepared := describeMedia(media, kind)
+
+	// The handle covers the type and name too, as both are sent along with the cached upload
+	hash := sha256.New()
+	hash.Write(media.Data)
+	hash.Write([]byte{0})
+	hash.Write([]byte(prepared.Mimetype))
+	hash.Write([]byte{0})
+	hash.Write([]byte(prepared.FileName))
+	mediaId := fmt.Sprintf("%s-%x", mediaKindPrefix(kind), hash.Sum(nil))
+
+	if cached, found := uploadcache.Get(fmt.Sprintf("%d:%s", userid, mediaId)); found {
+		copied := *cached.(*uploadedMedia)
+		return &copied, nil
+	}
+
+	prepared.MediaId = mediaId
+
+	uploaded, err := clientPointer[userid].Upload(context.Background(), media.Data, mediaType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file: %v", err)
+	}
+	prepared.UploadResponse = uploaded
+
+	uploadcache.Set(fmt.Sprintf("%d:%s", userid, mediaId), prepared, cache.DefaultExpiration)
+	copied := *prepared
+	copied.fresh = true
+	return &copied, nil
+}
+
+// Drops an upload the failed send made itself from the cache, so the next attempt uploads the file again.
+// Handles the caller supplied or that were already cached are kept, as a failed send says little about the media.
+func forgetUploadedMedia(userid int, prepared *uploadedMedia) {
+	if prepared != nil && prepared.fresh {
+		uploadcache.Delete(fmt.Sprintf("%d:%s", userid, prepared.MediaId))
+	}
+}
+
+// Gets previously uploaded media by its handle, checking it can be attached to the given kind of message
+func getUploadedMedia(userid int, mediaId string, kind string) (*uploadedMedia, error) {
+	if !strings.HasPrefix(mediaId, mediaKindPrefix(kind)+"-") {
+		return nil, fmt.Errorf("media was not uploaded for %s messages", kind)
+	}
+	cached, found := uploadcache.Get(fmt.Sprintf("%d:%s", userid, mediaId))
+	if !found {
+		return nil, errors.New("media not found or expired, upload it again")
+	}
+	prepared := *cached.(*uploadedMedia)
+	return &prepared, nil
+}
+
+// Gets the media of a send request, either from a handle returned by /chat/media/upload or by loading and uploading it
+func getSendMedia(userid int, upload *mediaFile, encoded string, mediaurl string, mediaId string, filename string, kind string) (*uploadedMedia, int, error) {
+	if mediaId != "" {
+		prepared, err := getUploadedMedia(userid, mediaId, kind)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		if filename != "" {
+			prepared.FileName = filename
+		}
+		return prepared, http.StatusOK, nil
+	}
+
+	media, err := loadMedia(upload, encoded, mediaurl, kind)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	if filename != "" {
+		media.FileName = filename
+	}
+
+	prepared, err := prepareMedia(userid, media, kind)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return prepared, http.StatusOK, nil
+}
+
+// Uploads media to be posted in a newsletter. Newsletter media is not encrypted, so it is uploaded apart from
+// chat media and never reused from the upload cache
+func prepareNewsletterMedia(userid int, media *mediaFile, kind string) (*uploadedMedia, error) {
+	prepared := describeMedia(media, kind)
+
+	uploaded, err := clientPointer[userid].UploadNewsletter(context.Background(), media.Data, mediaKinds[kind])
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file: %v", err)
+	}
+	prepared.UploadResponse = uploaded
+	return prepared, nil
+}
+
+// Gets the key of a newsletter invite, given either the key itself or a https://whatsapp.com/channel/ link
+func newsletterInviteKey(invite string) string {
+	invite = strings.TrimSpace(invite)
+	if parsed, err := url.Parse(invite); err == nil && parsed.Host != "" {
+		invite = parsed.Path
+	}
+	invite = strings.Trim(invite, "/")
+	return strings.TrimPrefix(invite, "channel/")
+}
+
+// Gets the JID of a newsletter given either directly or through an invite link
+func getNewsletterJID(userid int, jid string, invite string) (types.JID, int, error) {
+	if invite != "" {
+		info, err := clientPointer[userid].GetNewsletterInfoWithInvite(newsletterInviteKey(invite))
+		if err != nil {
+			return types.EmptyJID, http.StatusInternalServerError, fmt.Errorf("failed to get newsletter info: %v", err)
+		}
+		return info.ID, http.StatusOK, nil
+	}
+	newsletter, ok := parseJID(jid)
+	if !ok || newsletter.Server != types.NewsletterServer {
+		return types.EmptyJID, http.StatusBadRequest, errors.New("could not parse newsletter jid")
+	}
+	return newsletter, http.StatusOK, nil
+}
+
+// Parses a list of participant phone numbers or JIDs
+func parseParticipants(participants []string) ([]types.JID, error) {
+	if len(participants) == 0 {
+		return nil, errors.New("missing participants in payload")
+	}
+	jids := make([]types.JID, 0, len(participants))
+	for _, participant := range participants {
+		jid, ok := parseJID(participant)
+		if !ok {
+			return nil, fmt.Errorf("could not parse participant %s", participant)
+		}
+		jids = append(jids, jid)
+	}
+	return jids, nil
+}
+
+// Summarizes the outcome of a participant change. Status is 200 on success or the error code returned by WhatsApp,
+// such as 403 when the user must be invited instead, in which case the invite code to send them is included
+func participantResults(participants []types.GroupParticipant) []map[string]interface{} {
+	results := make([]map[string]interface{}, 0, len(participants))
+	for _, participant := range participants {
+		result := map[string]interface{}{"JID": participant.JID.String(), "Status": http.StatusOK}
+		if participant.Error != 0 {
+			result["Status"] = participant.Error
+		}
+		if participant.AddRequest != nil {
+			result["InviteCode"] = participant.AddRequest.Code
+			result["InviteExpiration"] = participant.AddRequest.Expiration
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Turns on or off the approval of new group members by admins. This whatsmeow version has no method for it, so the
+// group IQ is sent directly
+func setGroupJoinApproval(userid int, group types.JID, enabled bool) error {
+	state := "off"
+	if enabled {
+		state = "on"
+	}
+	_, err := clientPointer[userid].DangerousInternals().SendIQ(whatsmeow.DangerousInfoQuery{
+		Namespace: "w:g2",
+		Type:      "set",
+		To:        group,
+		Content: []waBinary.Node{{
+			Tag:     "membership_approval_mode",
+			Content: []waBinary.Node{{Tag: "group_join", Attrs: waBinary.Attrs{"state": state}}},
+		}},
+	})
+	return err
+}
+
+// Extracts the membership requests created or withdrawn in a group change, which whatsmeow leaves as unknown changes.
+// Requests withdrawn by someone other than the requester were rejected by an admin
+func getJoinRequests(evt *events.GroupInfo) []map[string]interface{} {
+	var requests []map[string]interface{}
+	for _, change := range evt.UnknownChanges {
+		if change.Tag != "created_membership_requests" && change.Tag != "revoked_membership_requests" {
+			continue
+		}
+		requesters := make([]types.JID, 0)
+		for _, participant := range change.GetChildrenByTag("participant") {
+			if jid := participant.AttrGetter().OptionalJID("jid"); jid != nil {
+				requesters = append(requesters, *jid)
+			}
+		}
+		if len(requesters) == 0 && evt.Sender != nil {
+			requesters = append(requesters, *evt.Sender)
+		}
+		for _, requester := range requesters {
+			request := map[string]interface{}{"GroupJID": evt.JID.String(), "JID": requester.String(), "Timestamp": evt.Timestamp}
+			if change.Tag == "created_membership_requests" {
+				request["Action"] = "created"
+				request["Method"] = change.AttrGetter().OptionalString("request_method")
+			} else if evt.Sender == nil || evt.Sender.User == requester.User {
+				request["Action"] = "cancelled"
+			} else {
+				request["Action"] = "rejected"
+				request["RejectedBy"] = evt.Sender.String()
+			}
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// Converts a list of JIDs to strings
+func jidStrings(jids []types.JID) []string {
+	list := make([]string, 0, len(jids))
+	for _, jid := range jids {
+		list = append(list, jid.String())
+	}
+	return list
+}
+
+// Describes the changes of a group change event, one entry per kind of change. Participant changes that affect the
+// account itself are reported as the account being added to or removed from the group
+func groupChanges(ownJID *types.JID, evt *events.GroupInfo) []map[string]interface{} {
+	var changes []map[string]interface{}
+	participantChange := func(action string, participants []types.JID) {
+		if len(participants) == 0 {
+			return
+		}
+		change := map[string]interface{}{"Action": action, "Participants": jidStrings(participants)}
+		if action == "join" && evt.JoinReason != "" {
+			change["Reason"] = evt.JoinReason
+		}
+		changes = append(changes, change)
+		for _, participant := range participants {
+			if ownJID != nil && participant.ToNonAD() == ownJID.ToNonAD() {
+				if action == "join" {
+					changes = append(changes, map[string]interface{}{"Action": "joined", "Reason": evt.JoinReason})
+				} else if action == "leave" && evt.Sender != nil && evt.Sender.User != ownJID.User {
+					changes = append(changes, map[string]interface{}{"Action": "removed", "RemovedBy": evt.Sender.ToNonAD().String()})
+				} else if action == "leave" {
+					changes = append(changes, map[string]interface{}{"Action": "left"})
+				}
+			}
+		}
+	}
+	participantChange("join", evt.Join)
+	participantChange("leave", evt.Leave)
+	participantChange("promote", evt.Promote)
+	participantChange("demote", evt.Demote)
+
+	if evt.Name != nil {
+		changes = append(changes, map[string]interface{}{"Action": "name", "Name": evt.Name.Name})
+	}
+	if evt.Topic != nil {
+		changes = append(changes, map[string]interface{}{"Action": "topic", "Topic": evt.Topic.Topic, "Deleted": evt.Topic.TopicDeleted})
+	}
+	if evt.Announce != nil {
+		changes = append(changes, map[string]interface{}{"Action": "announce", "Announce": evt.Announce.IsAnnounce})
+	}
+	if evt.Locked != nil {
+		changes = append(changes, map[string]interface{}{"Action": "locked", "Locked": evt.Locked.IsLocked})
+	}
+	if evt.Ephemeral != nil {
+		changes = append(changes, map[string]interface{}{"Action": "ephemeral", "Ephemeral": evt.Ephemeral.IsEphemeral, "DisappearingTimer": evt.Ephemeral.DisappearingTimer})
+	}
+	if evt.NewInviteLink != nil {
+		changes = append(changes, map[string]interface{}{"Action": "invitelink", "InviteLink": *evt.NewInviteLink})
+	}
+	if evt.Delete != nil {
+		changes = append(changes, map[string]interface{}{"Action": "delete", "Reason": evt.Delete.DeleteReason})
+	}
+	if evt.Link != nil {
+		changes = append(changes, map[string]interface{}{"Action": "link", "Type": evt.Link.Type, "Group": evt.Link.Group.JID.String(), "Name": evt.Link.Group.Name})
+	}
+	if evt.Unlink != nil {
+		changes = append(changes, map[string]interface{}{"Action": "unlink", "Type": evt.Unlink.Type, "Group": evt.Unlink.Group.JID.String(), "Name": evt.Unlink.Group.Name, "Reason": evt.Unlink.UnlinkReason})
+	}
+	for _, change := range evt.UnknownChanges {
+		if change.Tag == "membership_approval_mode" {
+			if groupJoin, ok := change.GetOptionalChildByTag("group_join"); ok {
+				changes = append(changes, map[string]interface{}{"Action": "joinapproval", "JoinApproval": groupJoin.AttrGetter().OptionalString("state") == "on"})
+			}
+		}
+	}
+	return changes
+}
+
+// Groups the account participates in, kept up to date with group events so listing them does not hit the server.
+// Entries are replaced rather than modified, so a GroupInfo obtained from the list is never changed afterwards
+type groupList struct {
+	sync.RWMutex
+	groups map[types.JID]*types.GroupInfo
+}
+
+// Gets the groups the account participates in, from the cache unless it is empty, expired or refresh is set
+func getJoinedGroups(userid int, refresh bool) ([]*types.GroupInfo, error) {
+	key := fmt.Sprint(userid)
+	if cached, found := groupcache.Get(key); found && !refresh {
+		list := cached.(*groupList)
+		list.RLock()
+		defer list.RUnlock()
+		groups := make([]*types.GroupInfo, 0, len(list.groups))
+		for _, info := range list.groups {
+			groups = append(groups, info)
+		}
+		return groups, nil
+	}
+
+	groups, err := clientPointer[userid].GetJoinedGroups()
+	if err != nil {
+		return nil, err
+	}
+	list := &groupList{groups: make(map[types.JID]*types.GroupInfo, len(groups))}
+	for _, info := range groups {
+		list.groups[info.JID] = info
+	}
+	groupcache.Set(key, list, cache.DefaultExpiration)
+	return groups, nil
+}
+
+// Applies a change to a cached group, if the group list is cached
+func updateCachedGroup(userid int, jid types.JID, update func(info *types.GroupInfo) *types.GroupInfo) {
+	cached, found := groupcache.Get(fmt.Sprint(userid))
+	if !found {
+		return
+	}
+	list := cached.(*groupList)
+	list.Lock()
+	defer list.Unlock()
+	var current *types.GroupInfo
+	if info, ok := list.groups[jid]; ok {
+		copied := *info
+		copied.Participants = append([]types.GroupParticipant(nil), info.Participants...)
+		current = &copied
+	}
+	if updated := update(current); updated != nil {
+		list.groups[jid] = updated
+	} else {
+		delete(list.groups, jid)
+	}
+}
+
+// Mirrors a group change event in the cached group list
+func applyGroupChange(userid int, ownJID *types.JID, evt *events.GroupInfo) {
+	updateCachedGroup(userid, evt.JID, func(info *types.GroupInfo) *types.GroupInfo {
+		if info == nil || evt.Delete != nil {
+			return nil
+		}
+		if evt.Name != nil {
+			info.GroupName = *evt.Name
+		}
+		if evt.Topic != nil {
+			info.GroupTopic = *evt.Topic
+		}
+		if evt.Locked != nil {
+			info.GroupLocked = *evt.Locked
+		}
+		if evt.Announce != nil {
+			info.GroupAnnounce = *evt.Announce
+		}
+		if evt.Ephemeral != nil {
+			info.GroupEphemeral = *evt.Ephemeral
+		}
+		for _, jid := range evt.Join {
+			info.Participants = append(info.Participants, types.GroupParticipant{JID: jid})
+		}
+		participants := info.Participants[:0]
+		for _, participant := range info.Participants {
+			left := false
+			for _, jid := range evt.Leave {
+				if participant.JID == jid {
+					left = true
+				}
+			}
+			if left {
+				// The account is no longer in the group, so it is dropped from the list
+				if ownJID != nil && participant.JID.ToNonAD() == ownJID.ToNonAD() {
+					return nil
+				}
+				continue
+			}
+			for _, jid := range evt.Promote {
+				if participant.JID == jid {
+					participant.IsAdmin = true
+				}
+			}
+			for _, jid := range evt.Demote {
+				if participant.JID == jid {
+					participant.IsAdmin = false
+					participant.IsSuperAdmin = false
+				}
+			}
+			participants = append(participants, participant)
+		}
+		info.Participants = participants
+		return info
+	})
+}
+
+// Summarizes a group for listings, leaving out the participant list unless it is asked for
+func groupSummary(info *types.GroupInfo, ownJID *types.JID, withParticipants bool) map[string]interface{} {
+	summary := map[string]interface{}{
+		"JID":              info.JID.String(),
+		"Name":             info.Name,
+		"ParticipantCount": len(info.Participants),
+		"IsAdmin":          false,
+		"IsAnnounce":       info.IsAnnounce,
+		"IsLocked":         info.IsLocked,
+		"IsCommunity":      info.IsParent,
+	}
+	if !info.LinkedParentJID.IsEmpty() {
+		summary["CommunityJID"] = info.LinkedParentJID.String()
+	}
+	if ownJID != nil {
+		for _, participant := range info.Participants {
+			if participant.JID.User == ownJID.User && participant.JID.Server == ownJID.Server {
+				summary["IsAdmin"] = participant.IsAdmin || participant.IsSuperAdmin
+			}
+		}
+	}
+	if withParticipants {
+		summary["Participants"] = info.Participants
+	}
+	return summary
+}
+
+// A privacy setting that can be changed through the API and the values WhatsApp accepts for it
+type privacyOption struct {
+	Name   string
+	Type   types.PrivacySettingType
+	Values []types.PrivacySetting
+}
+
+// Changeable privacy settings, named like the fields of types.PrivacySettings. Status is the visibility of the about text
+var privacyOptions = []privacyOption{
+	{"LastSeen", types.PrivacySettingTypeLastSeen, []types.PrivacySetting{types.PrivacySettingAll, types.PrivacySettingContacts, types.PrivacySettingContactBlacklist, types.PrivacySettingNone}},
+	{"Online", types.PrivacySettingTypeOnline, []types.PrivacySetting{types.PrivacySettingAll, types.PrivacySettingMatchLastSeen}},
+	{"Profile", types.PrivacySettingTypeProfile, []types.PrivacySetting{types.PrivacySettingAll, types.PrivacySettingContacts, types.PrivacySettingContactBlacklist, types.PrivacySettingNone}},
+	{"Status", types.PrivacySettingTypeStatus, []types.PrivacySetting{types.PrivacySettingAll, types.PrivacySettingContacts, types.PrivacySettingContactBlacklist, types.PrivacySettingNone}},
+	{"GroupAdd", types.PrivacySettingTypeGroupAdd, []types.PrivacySetting{types.PrivacySettingAll, types.PrivacySettingContacts, types.PrivacySettingContactBlacklist, types.PrivacySettingNone}},
+	{"ReadReceipts", types.PrivacySettingTypeReadReceipts, []types.PrivacySetting{types.PrivacySettingAll, types.PrivacySettingNone}},
+	{"CallAdd", types.PrivacySettingTypeCallAdd, []types.PrivacySetting{types.PrivacySettingAll, types.PrivacySettingKnown}},
+}
+
+// Validates the requested privacy changes, returning them in a fixed order so they are applied predictably
+func parsePrivacyChanges(changes map[string]string) ([]privacyOption, []types.PrivacySetting, error) {
+	if len(changes) == 0 {
+		return nil, nil, errors.New("missing privacy settings in payload")
+	}
+	var options []privacyOption
+	var values []types.PrivacySetting
+	for _, option := range privacyOptions {
+		value, ok := changes[option.Name]
+		if !ok {
+			continue
+		}
+		valid := false
+		for _, allowed := range option.Values {
+			if types.PrivacySetting(value) == allowed {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, nil, fmt.Errorf("invalid value %q for %s", value, option.Name)
+		}
+		options = append(options, option)
+		values = append(values, types.PrivacySetting(value))
+		delete(changes, option.Name)
+	}
+	for name := range changes {
+		return nil, nil, fmt.Errorf("unknown privacy setting %s", name)
+	}
+	return options, values, nil
+}
+
+// Lists the names of the privacy settings changed by a PrivacySettings event
+func privacyChanges(evt *events.PrivacySettings) []string {
+	changed := map[string]bool{
+		"LastSeen":     evt.LastSeenChanged,
+		"Online":       evt.OnlineChanged,
+		"Profile":      evt.ProfileChanged,
+		"Status":       evt.StatusChanged,
+		"GroupAdd":     evt.GroupAddChanged,
+		"ReadReceipts": evt.ReadReceiptsChanged,
+		"CallAdd":      evt.CallAddChanged,
+	}
+	names := []string{}
+	for _, option := range privacyOptions {
+		if changed[option.Name] {
+			names = append(names, option.Name)
+		}
+	}
+	return names
+}
+
+// Describes the blocklist with plain JID strings
+func blocklistSummary(blocklist *types.Blocklist) map[string]interface{} {
+	return map[string]interface{}{"DHash": blocklist.DHash, "JIDs": jidStrings(blocklist.JIDs)}
+}
+
+// Keeps the key of the newest message of every chat, which app state changes such as archiving or clearing a chat refer to.
+// It is saved to the database too, so chats can still be organized after a restart
+func storeLastMessage(userid int, info types.MessageInfo) {
+	if info.Chat == types.StatusBroadcastJID {
+		return
+	}
+	key := fmt.Sprintf("%d:%s", userid, info.Chat.String())
+	if last, found := lastmessages.Get(key); found && last.(types.MessageInfo).Timestamp.After(info.Timestamp) {
+		return
+	}
+	lastmessages.Set(key, info, cache.DefaultExpiration)
+
+	pendingLastMessages.Lock()
+	pendingLastMessages.messages[key] = &database.LastMessage{
+		UserID:    uint(userid),
+		Chat:      info.Chat.String(),
+		MessageID: info.ID,
+		Sender:    info.Sender.ToNonAD().String(),
+		FromMe:    info.IsFromMe,
+		Timestamp: info.Timestamp.Unix(),
+	}
+	pendingLastMessages.Unlock()
+}
+
+// Newest messages not saved to the database yet, keyed like lastmessages. Busy chats get many messages a second, so
+// only the newest one of each chat is written every few seconds
+var pendingLastMessages = struct {
+	sync.Mutex
+	messages map[string]*database.LastMessage
+}{messages: make(map[string]*database.LastMessage)}
+
+// Writes the pending newest messages to the database
+func saveLastMessages() {
+	pendingLastMessages.Lock()
+	messages := make([]*database.LastMessage, 0, len(pendingLastMessages.messages))
+	for _, message := range pendingLastMessages.messages {
+		messages = append(messages, message)
+	}
+	pendingLastMessages.messages = make(map[string]*database.LastMessage)
+	pendingLastMessages.Unlock()
+
+	if len(messages) > 0 && dbservice != nil {
+		dbservice.SetLastMessages(messages)
+	}
+}
+
+// Saves the pending newest messages every few seconds, until the process exits
+func saveLastMessagesLoop() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		saveLastMessages()
+	}
+}
+
+// Gets the newest message of a chat, loading it from the database when it is not in memory
+func getLastMessage(userid int, chat types.JID) (types.MessageInfo, bool) {
+	key := fmt.Sprintf("%d:%s", userid, chat.String())
+	if last, found := lastmessages.Get(key); found {
+		return last.(types.MessageInfo), true
+	}
+	if dbservice == nil {
+		return types.MessageInfo{}, false
+	}
+	saved, err := dbservice.GetLastMessage(uint(userid), chat.String())
+	if err != nil {
+		return types.MessageInfo{}, false
+	}
+	sender, _ := types.ParseJID(saved.Sender)
+	info := types.MessageInfo{
+		MessageSource: types.MessageSource{Chat: chat, Sender: sender, IsFromMe: saved.FromMe, IsGroup: chat.Server == types.GroupServer},
+		ID:            saved.MessageID,
+		Timestamp:     time.Unix(saved.Timestamp, 0),
+	}
+	lastmessages.Set(key, info, cache.DefaultExpiration)
+	return info, true
+}
+
+// Forgets the newest message of a deleted chat
+func deleteLastMessage(userid int, chat string) {
+	key := fmt.Sprintf("%d:%s", userid, chat)
+	lastmessages.Delete(key)
+	pendingLastMessages.Lock()
+	delete(pendingLastMessages.messages, key)
+	pendingLastMessages.Unlock()
+	if dbservice != nil {
+		dbservice.DeleteLastMessage(uint(userid), chat)
+	}
+}
+
+// Describes the messages an app state change applies to, up to the newest known message of the chat. When no message
+// is known the range only carries the current time
+func chatMessageRange(userid int, chat types.JID) (*waProto.SyncActionMessageRange, time.Time, *waProto.MessageKey) {
+	info, found := getLastMessage(userid, chat)
+	if !found {
+		now := time.Now()
+		return &waProto.SyncActionMessageRange{LastMessageTimestamp: proto.Int64(now.Unix())}, now, nil
+	}
+	key := &waProto.MessageKey{
+		RemoteJID: proto.String(chat.String()),
+		FromMe:    proto.Bool(info.IsFromMe),
+		ID:        proto.String(info.ID),
+	}
+	if info.IsGroup && !info.IsFromMe {
+		key.Participant = proto.String(info.Sender.ToNonAD().String())
+	}
+	return &waProto.SyncActionMessageRange{
+		LastMessageTimestamp: proto.Int64(info.Timestamp.Unix()),
+		Messages:             []*waProto.SyncActionMessage{{Key: key, Timestamp: proto.Int64(info.Timestamp.Unix())}},
+	}, info.Timestamp, key
+}
+
+// Parses how long a chat is muted for, as a Go duration or a number of days (7d) or weeks (1w). An empty duration or
+// always mutes the chat until it is unmuted
+func parseMuteDuration(text string) (time.Duration, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == "" || text == "always" {
+		return 0, nil
+	}
+	var duration time.Duration
+	var err error
+	if unit := text[len(text)-1]; unit == 'd' || unit == 'w' {
+		var count int
+		count, err = strconv.Atoi(text[:len(text)-1])
+		duration = time.Duration(count) * 24 * time.Hour
+		if unit == 'w' {
+			duration *= 7
+		}
+	} else {
+		duration, err = time.ParseDuration(text)
+	}
+	if err != nil || duration <= 0 {
+		return 0, errors.New("duration must be a positive duration such as 8h, 7d or 1w, or always")
+	}
+	return duration, nil
+}
+
+// Builds the app state patch of a chat action. This whatsmeow version only has builders for archiving, pinning and
+// muting, so marking as read or unread, clearing and deleting chats are built here the same way
+func buildChatPatch(userid int, chat types.JID, action string, muteDuration time.Duration, keepStarred bool, deleteMedia bool) (appstate.PatchInfo, error) {
+	messageRange, timestamp, key := chatMessageRange(userid, chat)
+	switch action {
+	case "archive", "unarchive":
+		return appstate.BuildArchive(chat, action == "archive", timestamp, key), nil
+	case "pin", "unpin":
+		return appstate.BuildPin(chat, action == "pin"), nil
+	case "mute", "unmute":
+		patch := appstate.BuildMute(chat, action == "mute", muteDuration)
+		if action == "mute" && muteDuration == 0 {
+			// WhatsApp marks chats muted until they are unmuted with an end of -1
+			patch.Mutations[0].Value.MuteAction.MuteEndTimestamp = proto.Int64(-1)
+		}
+		return patch, nil
+	case "read", "unread":
+		return appstate.PatchInfo{
+			Type: appstate.WAPatchRegularLow,
+			Mutations: []appstate.MutationInfo{{
+				Index:   []string{appstate.IndexMarkChatAsRead, chat.String()},
+				Version: 3,
+				Value: &waProto.SyncActionValue{
+					MarkChatAsReadAction: &waProto.MarkChatAsReadAction{Read: proto.Bool(action == "read"), MessageRange: messageRange},
+				},
+			}},
+		}, nil
+	case "clear":
+		return appstate.PatchInfo{
+			Type: appstate.WAPatchRegularHigh,
+			Mutations: []appstate.MutationInfo{{
+				Index:   []string{appstate.IndexClearChat, chat.String(), boolIndex(!keepStarred), boolIndex(deleteMedia)},
+				Version: 6,
+				Value:   &waProto.SyncActionValue{ClearChatAction: &waProto.ClearChatAction{MessageRange: messageRange}},
+			}},
+		}, nil
+	case "delete":
+		return appstate.PatchInfo{
+			Type: appstate.WAPatchRegularHigh,
+			Mutations: []appstate.MutationInfo{{
+				Index:   []string{appstate.IndexDeleteChat, chat.String(), boolIndex(deleteMedia)},
+				Version: 6,
+				Value:   &waProto.SyncActionValue{DeleteChatAction: &waProto.DeleteChatAction{MessageRange: messageRange}},
+			}},
+		}, nil
+	}
+	return appstate.PatchInfo{}, fmt.Errorf("unknown chat action %s", action)
+}
+
+// App state indexes encode flags as 1 or 0
+func boolIndex(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+// Tells whether an app state event comes from a full sync rather than from a change made on another device
+func fromFullSync(evt interface{}) bool {
+	switch evt := evt.(type) {
+	case *events.Contact:
+		return evt.FromFullSync
+	case *events.Pin:
+		return evt.FromFullSync
+	case *events.Star:
+		return evt.FromFullSync
+	case *events.DeleteForMe:
+		return evt.FromFullSync
+	case *events.Mute:
+		return evt.FromFullSync
+	case *events.Archive:
+		return evt.FromFullSync
+	case *events.MarkChatAsRead:
+		return evt.FromFullSync
+	case *events.ClearChat:
+		return evt.FromFullSync
+	case *events.DeleteChat:
+		return evt.FromFullSync
+	case *events.PushNameSetting:
+		return evt.FromFullSync
+	case *events.UnarchiveChatsSetting:
+		return evt.FromFullSync
+	case *events.UserStatusMute:
+		return evt.FromFullSync
+	case *events.LabelEdit:
+		return evt.FromFullSync
+	case *events.LabelAssociationChat:
+		return evt.FromFullSync
+	case *events.LabelAssociationMessage:
+		return evt.FromFullSync
+	}
+	return false
+}
+
+// Describes a chat organization change made on another device, with the same actions as the chat endpoints. Changes
+// replayed by a full app state sync describe the current state of every chat rather than a change, so they are skipped
+func chatStateChange(evt interface{}) map[string]interface{} {
+	var chat types.JID
+	var timestamp time.Time
+	change := map[string]interface{}{}
+	switch evt := evt.(type) {
+	case *events.Archive:
+		if evt.FromFullSync {
+			return nil
+		}
+		chat, timestamp = evt.JID, evt.Timestamp
+		change["Action"] = "unarchive"
+		if evt.Action.GetArchived() {
+			change["Action"] = "archive"
+		}
+	case *events.Pin:
+		if evt.FromFullSync {
+			return nil
+		}
+		chat, timestamp = evt.JID, evt.Timestamp
+		change["Action"] = "unpin"
+		if evt.Action.GetPinned() {
+			change["Action"] = "pin"
+		}
+	case *events.Mute:
+		if evt.FromFullSync {
+			return nil
+		}
+		chat, timestamp = evt.JID, evt.Timestamp
+		change["Action"] = "unmute"
+		if evt.Action.GetMuted() {
+			change["Action"] = "mute"
+			if end := evt.Action.GetMuteEndTimestamp(); end > 0 {
+				change["MuteEnd"] = end / 1000
+			} else if end < 0 {
+				change["MuteEnd"] = -1
+			}
+		}
+	case *events.MarkChatAsRead:
+		if evt.FromFullSync {
+			return nil
+		}
+		chat, timestamp = evt.JID, evt.Timestamp
+		change["Action"] = "unread"
+		if evt.Action.GetRead() {
+			change["Action"] = "read"
+		}
+	case *events.ClearChat:
+		if evt.FromFullSync {
+			return nil
+		}
+		chat, timestamp = evt.JID, evt.Timestamp
+		change["Action"] = "clear"
+	case *events.DeleteChat:
+		if evt.FromFullSync {
+			return nil
+		}
+		chat, timestamp = evt.JID, evt.Timestamp
+		change["Action"] = "delete"
+	default:
+		return nil
+	}
+	change["JID"] = chat.String()
+	change["Timestamp"] = timestamp
+	return change
+}
+
+// Number of label colors offered by WhatsApp Business, colors are given as an index into its palette
+const labelColors = 20
+
+// A WhatsApp Business label and the chats it is applied to
+type chatLabel struct {
+	Name         string
+	Color        int32
+	PredefinedID int32
+	Chats        map[types.JID]bool
+}
+
+// Labels of the account. whatsmeow does not store them, so they are built from label app state events, and synced
+// is set once a full resync of the labels has gone through the list. Resyncs and label creation are serialized by
+// their own locks, as both take a while and must not overlap with another one of the same account
+type labelList struct {
+	sync.RWMutex
+	labels   map[string]*chatLabel
+	synced   time.Time
+	syncLock sync.Mutex
+	newLock  sync.Mutex
+}
+
+// Gets the label list of the account, creating an empty one if needed
+func getLabelList(userid int) *labelList {
+	key := fmt.Sprint(userid)
+	for {
+		if cached, found := labelcache.Get(key); found {
+			return cached.(*labelList)
+		}
+		// Add fails when another event created the list first, which is then used instead
+		list := &labelList{labels: make(map[string]*chatLabel)}
+		if labelcache.Add(key, list, cache.DefaultExpiration) == nil {
+			return list
+		}
+	}
+}
+
+// Gets a label from the list, adding it if it is not known yet. Must be called with the list locked
+func (list *labelList) label(id string) *chatLabel {
+	label, ok := list.labels[id]
+	if !ok {
+		label = &chatLabel{Chats: make(map[types.JID]bool)}
+		list.labels[id] = label
+	}
+	return label
+}
+
+// Mirrors a label creation, edit or deletion in the label list
+func applyLabelEdit(userid int, id string, action *waProto.LabelEditAction) {
+	list := getLabelList(userid)
+	list.Lock()
+	defer list.Unlock()
+	if action.GetDeleted() {
+		delete(list.labels, id)
+		return
+	}
+	label := list.label(id)
+	label.Name = action.GetName()
+	label.Color = action.GetColor()
+	if action.PredefinedID != nil {
+		label.PredefinedID = action.GetPredefinedID()
+	}
+}
+
+// Creates, edits or deletes a label and mirrors the change in the label list
+func saveLabel(userid int, id string, name string, color int32, deleted bool) error {
+	err := clientPointer[userid].SendAppState(appstate.BuildLabelEdit(id, name, color, deleted))
+	if err != nil {
+		return err
+	}
+	applyLabelEdit(userid, id, &waProto.LabelEditAction{Name: proto.String(name), Color: proto.Int32(color), Deleted: proto.Bool(deleted)})
+	return nil
+}
+
+// Mirrors a chat being labeled or unlabeled in the label list
+func applyLabelChat(userid int, id string, chat types.JID, labeled bool) {
+	list := getLabelList(userid)
+	list.Lock()
+	defer list.Unlock()
+	if labeled {
+		list.label(id).Chats[chat] = true
+	} else if label, ok := list.labels[id]; ok {
+		delete(label.Chats, chat)
+	}
+}
+
+// Gets the labels of the account sorted by id. When the list was not synced yet or refresh is set, the labels app
+// state is fetched again from scratch. Full syncs emit events as the client is created with that flag on, and they
+// fill the list through the event handler before FetchAppState returns
+func getLabels(userid int, refresh bool) ([]map[string]interface{}, error) {
+	list := getLabelList(userid)
+	requested := time.Now()
+
+	list.syncLock.Lock()
+	list.RLock()
+	synced := list.synced
+	list.RUnlock()
+	// A resync that finished while waiting for the lock already answers a refresh
+	if synced.IsZero() || refresh && synced.Before(requested) {
+		list.Lock()
+		list.labels = make(map[string]*chatLabel)
+		list.synced = time.Time{}
+		list.Unlock()
+		err := clientPointer[userid].FetchAppState(appstate.WAPatchRegular, true, false)
+		if err != nil {
+			list.syncLock.Unlock()
+			return nil, err
+		}
+		list.Lock()
+		list.synced = time.Now()
+		list.Unlock()
+	}
+	list.syncLock.Unlock()
+
+	list.RLock()
+	defer list.RUnlock()
+	labels := make([]map[string]interface{}, 0, len(list.labels))
+	for id, label := range list.labels {
+		chats := make([]string, 0, len(label.Chats))
+		for chat := range label.Chats {
+			chats = append(chats, chat.String())
+		}
+		sort.Strings(chats)
+		labels = append(labels, map[string]interface{}{
+			"ID":           id,
+			"Name":         label.Name,
+			"Color":        label.Color,
+			"PredefinedID": label.PredefinedID,
+			"Chats":        chats,
+		})
+	}
+	// Label ids are increasing numbers, so shorter ids sort first
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i]["ID"].(string), labels[j]["ID"].(string)
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+	return labels, nil
+}
+
+// Gets a synced label by id
+func getLabel(userid int, id string) (*chatLabel, error) {
+	if _, err := getLabels(userid, false); err != nil {
+		return nil, err
+	}
+	list := getLabelList(userid)
+	list.RLock()
+	defer list.RUnlock()
+	label, ok := list.labels[id]
+	if !ok {
+		return nil, fmt.Errorf("label %s not found", id)
+	}
+	copied := *label
+	return &copied, nil
+}
+
+// Creates a label with the next free id, one more than the highest id in use. Creations of the same account are
+// serialized so two of them never pick the same id
+func createLabel(userid int, name string, color int32) (string, error) {
+	list := getLabelList(userid)
+	list.newLock.Lock()
+	defer list.newLock.Unlock()
+
+	labels, err := getLabels(userid, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to get labels: %v", err)
+	}
+	next := 1
+	for _, label := range labels {
+		if id, err := strconv.Atoi(label["ID"].(string)); err == nil && id >= next {
+			next = id + 1
+		}
+	}
+	id := strconv.Itoa(next)
+	err = saveLabel(userid, id, name, color, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to create label: %v", err)
+	}
+	return id, nil
+}
+
+// Days of the week as used in presence schedules, indexed by time.Weekday
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// How the account appears to its contacts. Available accounts are shown online, which stops the phone from showing
+// notifications but is needed to receive the presence of contacts. With business_hours the account is available on
+// the given Days between Start and End in TimeZone, and unavailable the rest of the time
+type presencePolicy struct {
+	Mode     string
+	Days     []string `json:",omitempty"`
+	Start    string   `json:",omitempty"`
+	End      string   `json:",omitempty"`
+	TimeZone string   `json:",omitempty"`
+}
+
+// Checks a presence policy, filling in the default days of business hours, monday to friday
+func (policy *presencePolicy) validate() error {
+	switch policy.Mode {
+	case "available", "unavailable":
+		*policy = presencePolicy{Mode: policy.Mode}
+		return nil
+	case "business_hours":
+	default:
+		return errors.New("mode must be one of available, unavailable or business_hours")
+	}
+	if len(policy.Days) == 0 {
+		policy.Days = []string{"mon", "tue", "wed", "thu", "fri"}
+	}
+	for i, day := range policy.Days {
+		policy.Days[i] = strings.ToLower(day)
+		if !Find(weekdays, policy.Days[i]) {
+			return fmt.Errorf("invalid day %s, days must be one of sun, mon, tue, wed, thu, fri or sat", day)
+		}
+	}
+	start, err := parseClock(policy.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(policy.End)
+	if err != nil {
+		return err
+	}
+	if start == end {
+		return errors.New("start and end must be different")
+	}
+	if policy.TimeZone != "" {
+		if _, err := time.LoadLocation(policy.TimeZone); err != nil {
+			return fmt.Errorf("invalid time zone %s", policy.TimeZone)
+		}
+	}
+	return nil
+}
+
+// Parses a time of day given as HH:MM into minutes since midnight
+func parseClock(text string) (int, error) {
+	clock, err := time.Parse("15:04", text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, times must be given as HH:MM", text)
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+// Gets the presence the policy asks for at the given time. Accounts without a policy stay unavailable. Business hours
+// that end before they start span midnight and belong to the day they start on
+func (policy presencePolicy) presenceAt(now time.Time) types.Presence {
+	switch policy.Mode {
+	case "available":
+		return types.PresenceAvailable
+	case "business_hours":
+	default:
+		return types.PresenceUnavailable
+	}
+	if policy.TimeZone != "" {
+		if location, err := time.LoadLocation(policy.TimeZone); err == nil {
+			now = now.In(location)
+		}
+	}
+	start, _ := parseClock(policy.Start)
+	end, _ := parseClock(policy.End)
+	minute := now.Hour()*60 + now.Minute()
+	today := Find(policy.Days, weekdays[now.Weekday()])
+	if start < end {
+		if today && minute >= start && minute < end {
+			return types.PresenceAvailable
+		}
+	} else if (today && minute >= start) || (Find(policy.Days, weekdays[(now.Weekday()+6)%7]) && minute < end) {
+		return types.PresenceAvailable
+	}
+	return types.PresenceUnavailable
+}
+
+// Presence policy of an account and the last presence sent for it
+type presenceState struct {
+	sync.Mutex
+	policy presencePolicy
+	sent   types.Presence
+}
+
+// Gets the presence state of an account, loading its policy from the database the first time
+func getPresenceState(userid int, service database.Service) *presenceState {
+	key := fmt.Sprint(userid)
+	if cached, found := presencecache.Get(key); found {
+		return cached.(*presenceState)
+	}
+	state := &presenceState{policy: presencePolicy{Mode: "unavailable"}}
+	user, err := service.GetUserById(userid)
+	if err == nil && user.Presence != "" {
+		err = json.Unmarshal([]byte(user.Presence), &state.policy)
+		if err != nil {
+			log.Warn().Err(err).Int("userid", userid).Msg("Invalid presence policy, staying unavailable")
+			state.policy = presencePolicy{Mode: "unavailable"}
+		}
+	}
+	presencecache.Set(key, state, cache.DefaultExpiration)
+	return state
+}
+
+// Sends the presence the policy of the account asks for. Unless force is set it is only sent when it differs from the
+// last one sent, so business hours can be checked often without flooding WhatsApp
+func sendPolicyPresence(client *whatsmeow.Client, userid int, service database.Service, force bool) (types.Presence, error) {
+	state := getPresenceState(userid, service)
+	state.Lock()
+	defer state.Unlock()
+	presence := state.policy.presenceAt(time.Now())
+	if !force && presence == state.sent {
+		return presence, nil
+	}
+	err := client.SendPresence(presence)
+	if err != nil {
+		return presence, err
+	}
+	state.sent = presence
+	return presence, nil
+}
+
+// Stores a new presence policy for the account and applies it right away
+func setPresencePolicy(client *whatsmeow.Client, userid int, service database.Service, policy presencePolicy) (types.Presence, error) {
+	encoded, err := json.Marshal(policy)
+	if err != nil {
+		return "", err
+	}
+	err = service.SetPresence(userid, string(encoded))
+	if err != nil {
+		return "", err
+	}
+	state := getPresenceState(userid, service)
+	state.Lock()
+	state.policy = policy
+	state.Unlock()
+	return sendPolicyPresence(client, userid, service, true)
+}
@@ -40,9 +40,19 @@ var (
 	sslcert    = flag.String("sslcertificate", "", "SSL Certificate File")
 	sslprivkey = flag.String("sslprivatekey", "", "SSL Certificate Private Key File")
 	container  *sqlstore.Container
+	dbservice  database.Service // for helpers that run outside of a handler or client
 
 	killchannel   = make(map[int](chan bool))
 	userinfocache = cache.New(1*time.Minute, 2*time.Minute)
+	messagecache  = cache.New(24*time.Hour, 1*time.Hour)
+	uploadcache   = cache.New(7*24*time.Hour, 1*time.Hour) // well within the time WhatsApp keeps uploaded media
+	pollcache     = cache.New(30*24*time.Hour, 1*time.Hour)
+	livelocations = cache.New(cache.NoExpiration, 5*time.Minute)
+	expirycache   = cache.New(7*24*time.Hour, 1*time.Hour)
+	groupcache    = cache.New(1*time.Hour, 10*time.Minute) // refreshed by group events in between
+	lastmessages  = cache.New(30*24*time.Hour, 1*time.Hour)
+	labelcache    = cache.New(cache.NoExpiration, 1*time.Hour)
+	presencecache = cache.New(cache.NoExpiration, 1*time.Hour)
 	log           zerolog.Logger
 )
 
@@ -97,6 +107,9 @@ func main() {
 		panic("Error starting database")
 	}
 
+	dbservice = service
+	go saveLastMessagesLoop()
+
 	if driver == "sqlite" {
 		connString = "file:" + connString + "?_pragma=foreign_keys(1)&_busy_timeout=3000"
 	}
@@ -162,6 +175,9 @@ func main() {
 		}
 	}()
 
+	// Messages received since the last periodic save would be lost otherwise
+	saveLastMessages()
+
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Error().Str("error", fmt.Sprintf("%+v", err)).Msg("Server Shutdown Failed")
 		os.Exit(1)
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestParseMuteDuration(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"always", 0, false},
+		{" Always ", 0, false},
+		{"8h", 8 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"1w", 7 * 24 * time.Hour, false},
+		{"2W", 14 * 24 * time.Hour, false},
+		{"0d", 0, true},
+		{"-1h", 0, true},
+		{"xd", 0, true},
+		{"forever", 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			got, err := parseMuteDuration(test.text)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.want {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBuildChatPatchMuteEnd(t *testing.T) {
+	chat := types.NewJID("5491155554444", types.DefaultUserServer)
+	tests := []struct {
+		name      string
+		action    string
+		duration  time.Duration
+		wantMuted bool
+		wantEnd   string
+	}{
+		{"mute for good", "mute", 0, true, "forever"},
+		{"mute for a duration", "mute", 8 * time.Hour, true, "duration"},
+		{"unmute", "unmute", 0, false, "none"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := time.Now().Truncate(time.Millisecond)
+			patch, err := buildChatPatch(0, chat, test.action, test.duration, false, false)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			mute := patch.Mutations[0].Value.GetMuteAction()
+			if mute.GetMuted() != test.wantMuted {
+				t.Fatalf("got muted %v, want %v", mute.GetMuted(), test.wantMuted)
+			}
+			switch test.wantEnd {
+			case "forever":
+				if mute.GetMuteEndTimestamp() != -1 {
+					t.Fatalf("got mute end %d, want -1", mute.GetMuteEndTimestamp())
+				}
+			case "duration":
+				end := time.UnixMilli(mute.GetMuteEndTimestamp())
+				if end.Before(before.Add(test.duration)) || end.After(time.Now().Add(test.duration)) {
+					t.Fatalf("got mute end %v, want %v from now", end, test.duration)
+				}
+			case "none":
+				if mute.MuteEndTimestamp != nil {
+					t.Fatalf("got mute end %d, want none", mute.GetMuteEndTimestamp())
+				}
+			}
+		})
+	}
+}
@@ -60,16 +60,26 @@ func (s *server) routes() {
 	s.router.Handle("/webhook", c.Then(s.SetWebhook())).Methods("POST")
 	s.router.Handle("/webhook", c.Then(s.GetWebhook())).Methods("GET")
 
+	s.router.Handle("/chat/media/upload", c.Then(s.UploadMedia())).Methods("POST")
 	s.router.Handle("/chat/send/text", c.Then(s.SendMessage())).Methods("POST")
 	s.router.Handle("/chat/send/image", c.Then(s.SendImage())).Methods("POST")
 	s.router.Handle("/chat/send/audio", c.Then(s.SendAudio())).Methods("POST")
 	s.router.Handle("/chat/send/document", c.Then(s.SendDocument())).Methods("POST")
-	//	s.router.Handle("/chat/send/template", c.Then(s.SendTemplate())).Methods("POST")
+	s.router.Handle("/chat/send/template", c.Then(s.SendTemplate())).Methods("POST")
 	s.router.Handle("/chat/send/video", c.Then(s.SendVideo())).Methods("POST")
 	s.router.Handle("/chat/send/sticker", c.Then(s.SendSticker())).Methods("POST")
 	s.router.Handle("/chat/send/location", c.Then(s.SendLocation())).Methods("POST")
+	s.router.Handle("/chat/send/livelocation", c.Then(s.SendLiveLocation())).Methods("POST")
+	s.router.Handle("/chat/send/livelocation/update", c.Then(s.UpdateLiveLocation())).Methods("POST")
+	s.router.Handle("/chat/send/livelocation/stop", c.Then(s.StopLiveLocation())).Methods("POST")
 	s.router.Handle("/chat/send/contact", c.Then(s.SendContact())).Methods("POST")
+	s.router.Handle("/chat/send/poll", c.Then(s.SendPoll())).Methods("POST")
 	s.router.Handle("/chat/react", c.Then(s.React())).Methods("POST")
+	s.router.Handle("/chat/edit", c.Then(s.EditMessage())).Methods("POST")
+	s.router.Handle("/chat/revoke", c.Then(s.RevokeMessage())).Methods("POST")
+	s.router.Handle("/chat/forward", c.Then(s.ForwardMessage())).Methods("POST")
+	s.router.Handle("/chat/disappearing", c.Then(s.SetDisappearingTimer())).Methods("POST")
+	s.router.Handle("/chat/disappearing/default", c.Then(s.SetDefaultDisappearingTimer())).Methods("POST")
 	s.router.Handle("/chat/send/buttons", c.Then(s.SendButtons())).Methods("POST")
 	s.router.Handle("/chat/send/list", c.Then(s.SendList())).Methods("POST")
 
@@ -77,19 +87,78 @@ func (s *server) routes() {
 	s.router.Handle("/user/check", c.Then(s.CheckUser())).Methods("POST")
 	s.router.Handle("/user/avatar", c.Then(s.GetAvatar())).Methods("POST")
 	s.router.Handle("/user/contacts", c.Then(s.GetContacts())).Methods("GET")
+	s.router.Handle("/user/profile/name", c.Then(s.SetPushName())).Methods("POST")
+	s.router.Handle("/user/profile/about", c.Then(s.SetAbout())).Methods("POST")
+	s.router.Handle("/user/profile/photo", c.Then(s.SetProfilePhoto())).Methods("POST")
+	s.router.Handle("/user/business", c.Then(s.GetBusinessProfile())).Methods("POST")
+	s.router.Handle("/user/privacy", c.Then(s.GetPrivacySettings())).Methods("GET")
+	s.router.Handle("/user/privacy", c.Then(s.SetPrivacySettings())).Methods("POST")
+	s.router.Handle("/user/blocklist", c.Then(s.GetBlocklist())).Methods("GET")
+	s.router.Handle("/user/block", c.Then(s.UpdateBlocklist(true))).Methods("POST")
+	s.router.Handle("/user/unblock", c.Then(s.UpdateBlocklist(false))).Methods("POST")
+	s.router.Handle("/user/presence", c.Then(s.GetPresence())).Methods("GET")
+	s.router.Handle("/user/presence", c.Then(s.SetPresence())).Methods("POST")
+	s.router.Handle("/user/presence/subscribe", c.Then(s.SubscribePresence())).Methods("POST")
 
 	s.router.Handle("/chat/presence", c.Then(s.ChatPresence())).Methods("POST")
 	s.router.Handle("/chat/markread", c.Then(s.MarkRead())).Methods("POST")
-	s.router.Handle("/chat/downloadimage", c.Then(s.DownloadImage())).Methods("POST")
-	s.router.Handle("/chat/downloadvideo", c.Then(s.DownloadVideo())).Methods("POST")
-	s.router.Handle("/chat/downloadaudio", c.Then(s.DownloadAudio())).Methods("POST")
-	s.router.Handle("/chat/downloaddocument", c.Then(s.DownloadDocument())).Methods("POST")
+	s.router.Handle("/chat/archive", c.Then(s.UpdateChat("archive"))).Methods("POST")
+	s.router.Handle("/chat/unarchive", c.Then(s.UpdateChat("unarchive"))).Methods("POST")
+	s.router.Handle("/chat/pin", c.Then(s.UpdateChat("pin"))).Methods("POST")
+	s.router.Handle("/chat/unpin", c.Then(s.UpdateChat("unpin"))).Methods("POST")
+	s.router.Handle("/chat/mute", c.Then(s.UpdateChat("mute"))).Methods("POST")
+	s.router.Handle("/chat/unmute", c.Then(s.UpdateChat("unmute"))).Methods("POST")
+	s.router.Handle("/chat/read", c.Then(s.UpdateChat("read"))).Methods("POST")
+	s.router.Handle("/chat/unread", c.Then(s.UpdateChat("unread"))).Methods("POST")
+	s.router.Handle("/chat/clear", c.Then(s.UpdateChat("clear"))).Methods("POST")
+	s.router.Handle("/chat/delete", c.Then(s.UpdateChat("delete"))).Methods("POST")
+	s.router.Handle("/chat/download", c.Then(s.Download(""))).Methods("POST")
+	// Legacy per-type download routes, kept for compatibility
+	s.router.Handle("/chat/downloadimage", c.Then(s.Download("image"))).Methods("POST")
+	s.router.Handle("/chat/downloadvideo", c.Then(s.Download("video"))).Methods("POST")
+	s.router.Handle("/chat/downloadaudio", c.Then(s.Download("audio"))).Methods("POST")
+	s.router.Handle("/chat/downloaddocument", c.Then(s.Download("document"))).Methods("POST")
+
+	s.router.Handle("/status/send", c.Then(s.SendStatus())).Methods("POST")
+	s.router.Handle("/status/privacy", c.Then(s.GetStatusPrivacy())).Methods("GET")
+
+	s.router.Handle("/newsletter/list", c.Then(s.ListNewsletters())).Methods("GET")
+	s.router.Handle("/newsletter/info", c.Then(s.GetNewsletterInfo())).Methods("GET")
+	s.router.Handle("/newsletter/follow", c.Then(s.FollowNewsletter(true))).Methods("POST")
+	s.router.Handle("/newsletter/unfollow", c.Then(s.FollowNewsletter(false))).Methods("POST")
+	s.router.Handle("/newsletter/mute", c.Then(s.MuteNewsletter())).Methods("POST")
+	s.router.Handle("/newsletter/create", c.Then(s.CreateNewsletter())).Methods("POST")
+	s.router.Handle("/newsletter/send", c.Then(s.SendNewsletterMessage())).Methods("POST")
+	s.router.Handle("/newsletter/messages", c.Then(s.GetNewsletterMessages())).Methods("GET")
 
 	s.router.Handle("/group/list", c.Then(s.ListGroups())).Methods("GET")
 	s.router.Handle("/group/info", c.Then(s.GetGroupInfo())).Methods("GET")
 	s.router.Handle("/group/invitelink", c.Then(s.GetGroupInviteLink())).Methods("GET")
 	s.router.Handle("/group/photo", c.Then(s.SetGroupPhoto())).Methods("POST")
 	s.router.Handle("/group/name", c.Then(s.SetGroupName())).Methods("POST")
+	s.router.Handle("/group/create", c.Then(s.CreateGroup(false))).Methods("POST")
+	s.router.Handle("/group/participants", c.Then(s.UpdateGroupParticipants())).Methods("POST")
+	s.router.Handle("/group/leave", c.Then(s.LeaveGroup())).Methods("POST")
+	s.router.Handle("/group/topic", c.Then(s.SetGroupTopic())).Methods("POST")
+	s.router.Handle("/group/settings", c.Then(s.SetGroupSettings())).Methods("POST")
+	s.router.Handle("/group/requests", c.Then(s.GetGroupRequests())).Methods("GET")
+	s.router.Handle("/group/requests/update", c.Then(s.UpdateGroupRequests())).Methods("POST")
+	s.router.Handle("/group/invitelink/revoke", c.Then(s.RevokeGroupInviteLink())).Methods("POST")
+	s.router.Handle("/group/inviteinfo", c.Then(s.GetGroupInviteInfo())).Methods("GET")
+	s.router.Handle("/group/join", c.Then(s.JoinGroup())).Methods("POST")
+
+	s.router.Handle("/community/create", c.Then(s.CreateGroup(true))).Methods("POST")
+	s.router.Handle("/community/link", c.Then(s.LinkCommunityGroup(true))).Methods("POST")
+	s.router.Handle("/community/unlink", c.Then(s.LinkCommunityGroup(false))).Methods("POST")
+	s.router.Handle("/community/subgroups", c.Then(s.GetCommunitySubGroups())).Methods("GET")
+	s.router.Handle("/community/participants", c.Then(s.GetCommunityParticipants())).Methods("GET")
+
+	s.router.Handle("/label/list", c.Then(s.ListLabels())).Methods("GET")
+	s.router.Handle("/label/create", c.Then(s.CreateLabel())).Methods("POST")
+	s.router.Handle("/label/edit", c.Then(s.EditLabel(false))).Methods("POST")
+	s.router.Handle("/label/delete", c.Then(s.EditLabel(true))).Methods("POST")
+	s.router.Handle("/label/apply", c.Then(s.ApplyLabel(true))).Methods("POST")
+	s.router.Handle("/label/remove", c.Then(s.ApplyLabel(false))).Methods("POST")
 
 	s.router.Handle("/users/create", c.Then(s.CreateUser())).Methods("POST")
 	s.router.Handle("/users/delete/{id}", c.Then(s.DeleteUser())).Methods("DELETE")
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The package parses its flags on init, so the test flags have to be registered before that
+var _ = func() bool {
+	testing.Init()
+	return true
+}()
+
+func TestContactCardValidate(t *testing.T) {
+	phones := []contactPhone{{Number: "+1 555 0100"}}
+	tests := []struct {
+		name    string
+		contact contactCard
+		wantErr string
+	}{
+		{"full name", contactCard{FullName: "Ann", Phones: phones}, ""},
+		{"first and last name", contactCard{FirstName: "Ann", LastName: "Lee", Phones: phones}, ""},
+		{"missing name", contactCard{Phones: phones}, "missing name"},
+		{"missing phones", contactCard{FullName: "Ann"}, "missing phones"},
+		{"phone type any case", contactCard{FullName: "Ann", Phones: []contactPhone{{Number: "1", Type: "Work"}}}, ""},
+		{"unknown phone type", contactCard{FullName: "Ann", Phones: []contactPhone{{Number: "1", Type: "cell;waid=1"}}}, "phone type"},
+		{"valid waid", contactCard{FullName: "Ann", Phones: []contactPhone{{Number: "1", WaId: "15550100"}}}, ""},
+		{"waid with letters", contactCard{FullName: "Ann", Phones: []contactPhone{{Number: "1", WaId: "1555x0100"}}}, "waid"},
+		{"waid too short", contactCard{FullName: "Ann", Phones: []contactPhone{{Number: "1", WaId: "1234"}}}, "waid"},
+		{"waid too long", contactCard{FullName: "Ann", Phones: []contactPhone{{Number: "1", WaId: "12345678901234567"}}}, "waid"},
+		{"email type", contactCard{FullName: "Ann", Phones: phones, Emails: []contactEmail{{Address: "a@b.c", Type: "work"}}}, ""},
+		{"unknown email type", contactCard{FullName: "Ann", Phones: phones, Emails: []contactEmail{{Address: "a@b.c", Type: "x;y"}}}, "email type"},
+		{"url", contactCard{FullName: "Ann", Phones: phones, Url: "https://example.com/a,b"}, ""},
+		{"url with line break", contactCard{FullName: "Ann", Phones: phones, Url: "https://example.com\nEND:VCARD"}, "url"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.contact.validate()
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("expected error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestContactCardVcard(t *testing.T) {
+	tests := []struct {
+		name    string
+		contact contactCard
+		want    []string
+	}{
+		{
+			"escaping",
+			contactCard{FullName: `A, B; C\D`, FirstName: "B", LastName: "A;", Organization: "Acme\nInc", Phones: []contactPhone{{Number: "+1 555"}}},
+			[]string{`N:A\;;B;;;`, `FN:A\, B\; C\\D`, `ORG:Acme\nInc`},
+		},
+		{
+			"default phone type and waid",
+			contactCard{FullName: "Ann", Phones: []contactPhone{{Number: "+1 (555) 0100"}}},
+			[]string{"TEL;type=CELL;type=VOICE;waid=15550100:+1 (555) 0100"},
+		},
+		{
+			"given phone type and waid",
+			contactCard{FullName: "Ann", Phones: []contactPhone{{Number: "+1 555", Type: "work", WaId: "15550199"}}},
+			[]string{"TEL;type=WORK;type=VOICE;waid=15550199:+1 555"},
+		},
+		{
+			"emails",
+			contactCard{FullName: "Ann", Phones: []contactPhone{{Number: "1"}}, Emails: []contactEmail{{Address: "a@b.c"}, {Address: "d@e.f", Type: "work"}}},
+			[]string{"EMAIL;type=INTERNET;type=HOME:a@b.c", "EMAIL;type=INTERNET;type=WORK:d@e.f"},
+		},
+		{
+			"url is not escaped",
+			contactCard{FullName: "Ann", Phones: []contactPhone{{Number: "1"}}, Url: "https://example.com/a,b;c"},
+			[]string{"URL:https://example.com/a,b;c"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vcard := test.contact.vcard()
+			lines := strings.Split(vcard, "\n")
+			if lines[0] != "BEGIN:VCARD" || lines[len(lines)-1] != "END:VCARD" {
+				t.Fatalf("vCard is not delimited by BEGIN and END:\n%s", vcard)
+			}
+			for _, want := range test.want {
+				found := false
+				for _, line := range lines {
+					if line == want {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("missing line %q in:\n%s", want, vcard)
+				}
+			}
+		})
+	}
+}
+
+func TestVcardRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		contact contactCard
+		want    contactCard
+	}{
+		{
+			"escaped fields",
+			contactCard{FullName: `A, B; C\D`, FirstName: "B,", LastName: "A;", Organization: "Acme\nInc", Phones: []contactPhone{{Number: "+1 555;0100"}}},
+			contactCard{FullName: `A, B; C\D`, FirstName: "B,", LastName: "A;", Organization: "Acme\nInc", Phones: []contactPhone{{Number: "+1 555;0100", Type: "CELL", WaId: "15550100"}}},
+		},
+		{
+			"types and url",
+			contactCard{
+				FirstName: "Ann",
+				LastName:  "Lee",
+				Url:       "https://example.com/a,b",
+				Phones:    []contactPhone{{Number: "+1 555", Type: "home", WaId: "15550199"}},
+				Emails:    []contactEmail{{Address: "a@b.c", Type: "work"}},
+			},
+			contactCard{
+				FullName:  "Ann Lee",
+				FirstName: "Ann",
+				LastName:  "Lee",
+				Url:       "https://example.com/a,b",
+				Phones:    []contactPhone{{Number: "+1 555", Type: "HOME", WaId: "15550199"}},
+				Emails:    []contactEmail{{Address: "a@b.c", Type: "WORK"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.contact.validate(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := parseVcard(test.contact.vcard())
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseVcard(t *testing.T) {
+	tests := []struct {
+		name  string
+		vcard string
+		want  contactCard
+	}{
+		{
+			"type lists",
+			"BEGIN:VCARD\nTEL;TYPE=voice,work:+1 555\nEMAIL;TYPE=\"internet,home\":a@b.c\nEND:VCARD",
+			contactCard{Phones: []contactPhone{{Number: "+1 555", Type: "WORK"}}, Emails: []contactEmail{{Address: "a@b.c", Type: "HOME"}}},
+		},
+		{
+			"repeated types",
+			"BEGIN:VCARD\nTEL;type=PREF;type=CELL;type=VOICE;waid=15550100:+1 555 0100\nEND:VCARD",
+			contactCard{Phones: []contactPhone{{Number: "+1 555 0100", Type: "CELL", WaId: "15550100"}}},
+		},
+		{
+			"grouped properties and folded lines",
+			"BEGIN:VCARD\r\nFN:Ann\r\n  Lee\r\nitem1.TEL:+1 555\r\nitem1.X-ABLabel:Phone\r\nORG:Acme;\r\nEND:VCARD",
+			contactCard{FullName: "Ann Lee", Organization: "Acme", Phones: []contactPhone{{Number: "+1 555"}}},
+		},
+		{
+			"uppercase newline escape",
+			"BEGIN:VCARD\nFN:Ann\\NLee\nEND:VCARD",
+			contactCard{FullName: "Ann\nLee"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := parseVcard(test.vcard)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
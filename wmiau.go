@@ -179,6 +179,10 @@ func (s *server) startClient(userID int, textjid string, token string, subscript
 		client = whatsmeow.NewClient(deviceStore, nil)
 	}
 	// client.SetForceActiveDeliveryReceipts(false)
+	// Full app state syncs emit events too, labels are only known from them. whatsmeow reads the flag while
+	// syncing, so it is set once here and never changed afterwards. myEventHandler drops these events once the
+	// labels are applied; contacts are still stored by whatsmeow, one by one instead of in bulk
+	client.EmitAppStateEventsOnFullSync = true
 
 	clientPointer[userID] = client
 	mycli := MyClient{client, 1, userID, token, subscriptions, s.db, s.service, instance}
@@ -279,8 +283,19 @@ func (s *server) startClient(userID int, textjid string, token string, subscript
 		}
 	}
 
+	// Business hours presence policies switch between available and unavailable, so the policy is checked every minute
+	presenceTicker := time.NewTicker(time.Minute)
+	defer presenceTicker.Stop()
+
 	for {
 		select {
+		case <-presenceTicker.C:
+			if client.IsConnected() && len(client.Store.PushName) > 0 {
+				_, err := sendPolicyPresence(client, userID, s.service, false)
+				if err != nil {
+					log.Warn().Err(err).Msg("Failed to send scheduled presence")
+				}
+			}
 		case <-killchannel[userID]:
 			// log.Info().Str("userid", strconv.Itoa(userID)).Msg("Received kill signal")
 			client.Disconnect()
@@ -296,6 +311,12 @@ func (s *server) startClient(userID int, textjid string, token string, subscript
 	}
 }
 
+// Join requests found in a group change, handled as an event of their own
+type groupJoinRequests struct {
+	*events.GroupInfo
+	requests []map[string]interface{}
+}
+
 func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 	txtid := strconv.Itoa(mycli.userID)
 	postmap := make(map[string]interface{})
@@ -311,19 +332,39 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 	}
 	exPath := filepath.Dir(ex)
 
+	// Full app state syncs are only emitted to fill the label list, so they go no further than that: they would
+	// otherwise reach Redis and webhooks in bulk, and a synced push name would count as connecting again
+	if fromFullSync(rawEvt) {
+		switch evt := rawEvt.(type) {
+		case *events.LabelEdit:
+			applyLabelEdit(mycli.userID, evt.LabelID, evt.Action)
+		case *events.LabelAssociationChat:
+			applyLabelChat(mycli.userID, evt.LabelID, evt.JID, evt.Action.GetLabeled())
+		}
+		return
+	}
+	// Raw app state changes come along with the typed events handled below
+	if _, ok := rawEvt.(*events.AppState); ok {
+		return
+	}
+
 	switch evt := rawEvt.(type) {
 	case *events.AppStateSyncComplete:
 		if len(mycli.WAClient.Store.PushName) > 0 && evt.Name == appstate.WAPatchCriticalBlock {
-			err := mycli.WAClient.SendPresence(types.PresenceUnavailable)
+			_, err := sendPolicyPresence(mycli.WAClient, mycli.userID, mycli.service, true)
 			if err != nil {
-				log.Warn().Err(err).Msg("Failed to send available presence")
+				log.Warn().Err(err).Msg("Failed to send presence")
 			}
-			// else {
-			// 	// log.Info().Msg("Marked self as available")
-			// }
 		}
 	case *events.Connected, *events.PushNameSetting:
 		log.Info().Msg("Connected event received")
+		if _, reconnected := rawEvt.(*events.Connected); reconnected {
+			// Group changes made while disconnected produce no events, so the list is loaded again
+			groupcache.Delete(fmt.Sprint(mycli.userID))
+		}
+		if user, err := mycli.service.GetUserById(mycli.userID); err == nil {
+			storeDefaultDisappearingTimer(mycli.userID, uint32(user.DisappearingTimer))
+		}
 		// erro := mycli.service.SetCountMsg(uint(mycli.userID), "online")
 		// if erro != nil {
 		// 	log.Error().Err(err).Msg("Could not update count messages")
@@ -332,15 +373,12 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 		if len(mycli.WAClient.Store.PushName) == 0 {
 			return
 		}
-		// Send presence available when connecting and when the pushname is changed.
+		// Send the presence asked for by the presence policy when connecting and when the pushname is changed.
 		// This makes sure that outgoing messages always have the right pushname.
-		err := mycli.WAClient.SendPresence(types.PresenceUnavailable)
+		_, err := sendPolicyPresence(mycli.WAClient, mycli.userID, mycli.service, true)
 		if err != nil {
-			log.Warn().Err(err).Msg("Failed to send available presence")
+			log.Warn().Err(err).Msg("Failed to send presence")
 		}
-		// else {
-		// 	// log.Info().Msg("Marked self as available")
-		// }
 		err = mycli.service.SetConnected(mycli.userID)
 
 		if err != nil {
@@ -394,6 +432,123 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 	case *events.Message:
 		postmap["type"] = "Message"
 		dowebhook = 1
+
+		// Status updates posted by contacts are delivered apart from chat messages
+		if evt.Info.Chat == types.StatusBroadcastJID {
+			postmap["type"] = "Status"
+			status := map[string]interface{}{
+				"Id":        evt.Info.ID,
+				"Sender":    evt.Info.Sender.ToNonAD().String(),
+				"Timestamp": evt.Info.Timestamp,
+			}
+			if protocolMessage := evt.Message.GetProtocolMessage(); protocolMessage.GetType() == waProto.ProtocolMessage_REVOKE {
+				status["Id"] = protocolMessage.GetKey().GetID()
+				status["Revoked"] = true
+				deleteStoredMessage(mycli.userID, protocolMessage.GetKey().GetID())
+			} else {
+				storeMessage(mycli.userID, evt)
+			}
+			postmap["status"] = status
+		} else if protocolMessage := evt.Message.GetProtocolMessage(); protocolMessage != nil {
+			// Edits and revokes arrive as protocol messages, they are delivered as their own event types
+			key := protocolMessage.GetKey()
+			switch protocolMessage.GetType() {
+			case waProto.ProtocolMessage_MESSAGE_EDIT:
+				postmap["type"] = "Edited"
+				postmap["edited"] = map[string]interface{}{
+					"Id":        key.GetID(),
+					"Chat":      evt.Info.Chat.String(),
+					"Sender":    evt.Info.Sender.ToNonAD().String(),
+					"Message":   protocolMessage.GetEditedMessage(),
+					"Timestamp": time.UnixMilli(protocolMessage.GetTimestampMS()),
+				}
+				if stored, found := getStoredMessage(mycli.userID, key.GetID()); found {
+					edited := *stored
+					edited.Message = protocolMessage.GetEditedMessage()
+					edited.IsEdit = true
+					storeMessage(mycli.userID, &edited)
+				}
+			case waProto.ProtocolMessage_EPHEMERAL_SETTING:
+				storeDisappearingTimer(mycli.userID, evt.Info.Chat, protocolMessage.GetEphemeralExpiration())
+			case waProto.ProtocolMessage_REVOKE:
+				// In groups the participant of the key is the author of the revoked message, which differs
+				// from the sender when an admin deletes someone else's message
+				author := evt.Info.Sender.ToNonAD().String()
+				if key.GetParticipant() != "" {
+					author = key.GetParticipant()
+				}
+				revoked := map[string]interface{}{
+					"Id":        key.GetID(),
+					"Chat":      evt.Info.Chat.String(),
+					"Sender":    author,
+					"RevokedBy": evt.Info.Sender.ToNonAD().String(),
+					"Timestamp": evt.Info.Timestamp,
+				}
+				if stored, found := getStoredMessage(mycli.userID, key.GetID()); found {
+					revoked["Message"] = stored.Message
+					deleteStoredMessage(mycli.userID, key.GetID())
+				}
+				postmap["type"] = "Revoked"
+				postmap["revoked"] = revoked
+			}
+		} else if pollUpdate := evt.Message.GetPollUpdateMessage(); pollUpdate != nil {
+			postmap["type"] = "PollVote"
+			pollId := pollUpdate.GetPollCreationMessageKey().GetID()
+			vote := map[string]interface{}{
+				"PollId":    pollId,
+				"Chat":      evt.Info.Chat.String(),
+				"Voter":     evt.Info.Sender.ToNonAD().String(),
+				"Timestamp": evt.Info.Timestamp,
+			}
+			pollVote, err := mycli.WAClient.DecryptPollVote(evt)
+			if err != nil {
+				// Still report the vote, with the encrypted payload, so it is not silently lost
+				log.Warn().Err(err).Str("id", evt.Info.ID).Msg("Failed to decrypt poll vote")
+				vote["Decrypted"] = false
+				vote["KnownPoll"] = false
+				vote["SelectedOptions"] = []string{}
+				vote["EncPayload"] = pollUpdate.GetVote().GetEncPayload()
+				vote["EncIv"] = pollUpdate.GetVote().GetEncIV()
+			} else {
+				selected, known := getPollVoteOptions(mycli.userID, pollId, pollVote.GetSelectedOptions())
+				vote["Decrypted"] = true
+				vote["KnownPoll"] = known
+				vote["SelectedOptions"] = selected
+			}
+			postmap["pollVote"] = vote
+		} else {
+			storeMessage(mycli.userID, evt)
+			if expiration := getContextInfo(evt.Message).GetExpiration(); expiration > 0 {
+				storeDisappearingTimer(mycli.userID, evt.Info.Chat, expiration)
+			}
+			if contacts := getSharedContacts(evt.Message); len(contacts) > 0 {
+				postmap["contacts"] = contacts
+			}
+			if live := evt.Message.GetLiveLocationMessage(); live != nil {
+				postmap["type"] = "LiveLocation"
+				postmap["liveLocation"] = map[string]interface{}{
+					"Id":               evt.Info.ID,
+					"Chat":             evt.Info.Chat.String(),
+					"Sender":           evt.Info.Sender.ToNonAD().String(),
+					"Latitude":         live.GetDegreesLatitude(),
+					"Longitude":        live.GetDegreesLongitude(),
+					"AccuracyInMeters": live.GetAccuracyInMeters(),
+					"SpeedInMps":       live.GetSpeedInMps(),
+					"Heading":          live.GetDegreesClockwiseFromMagneticNorth(),
+					"Caption":          live.GetCaption(),
+					"SequenceNumber":   live.GetSequenceNumber(),
+					"TimeOffset":       live.GetTimeOffset(),
+					"Timestamp":        evt.Info.Timestamp,
+				}
+			}
+			if reply := parseInteractiveReply(evt.Message); reply != nil {
+				postmap["type"] = "InteractiveReply"
+				reply["Chat"] = evt.Info.Chat.String()
+				reply["Sender"] = evt.Info.Sender.ToNonAD().String()
+				reply["Timestamp"] = evt.Info.Timestamp
+				postmap["interactiveReply"] = reply
+			}
+		}
 		metaParts := []string{fmt.Sprintf("pushname: %s", evt.Info.PushName), fmt.Sprintf("timestamp: %s", evt.Info.Timestamp)}
 		if evt.Info.Type != "" {
 			metaParts = append(metaParts, fmt.Sprintf("type: %s", evt.Info.Type))
@@ -573,9 +728,47 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 		// }
 		// // log.Info().Str("filename", fileName).Msg("Wrote history sync")
 		// _ = file.Close()
-	case *events.AppState:
-		// log.Info().Str("index", fmt.Sprintf("%+v", evt.Index)).Str("actionValue", fmt.Sprintf("%+v", evt.SyncActionValue)).Msg("App state event received")
+	case *events.Archive, *events.Pin, *events.Mute, *events.MarkChatAsRead, *events.ClearChat, *events.DeleteChat:
+		if change := chatStateChange(evt); change != nil {
+			if change["Action"] == "delete" {
+				deleteLastMessage(mycli.userID, fmt.Sprint(change["JID"]))
+			}
+			postmap["type"] = "ChatState"
+			postmap["chatState"] = change
+			dowebhook = 1
+		}
+	case *events.LabelEdit:
+		applyLabelEdit(mycli.userID, evt.LabelID, evt.Action)
+		label := map[string]interface{}{"Action": "edit", "ID": evt.LabelID, "Name": evt.Action.GetName(), "Color": evt.Action.GetColor(), "Timestamp": evt.Timestamp}
+		if evt.Action.GetDeleted() {
+			label = map[string]interface{}{"Action": "delete", "ID": evt.LabelID, "Timestamp": evt.Timestamp}
+		}
+		postmap["type"] = "Label"
+		postmap["label"] = label
+		dowebhook = 1
+	case *events.LabelAssociationChat:
+		applyLabelChat(mycli.userID, evt.LabelID, evt.JID, evt.Action.GetLabeled())
+		label := map[string]interface{}{"Action": "remove", "ID": evt.LabelID, "JID": evt.JID.String(), "Timestamp": evt.Timestamp}
+		if evt.Action.GetLabeled() {
+			label["Action"] = "apply"
+		}
+		postmap["type"] = "Label"
+		postmap["label"] = label
+		dowebhook = 1
+	case *events.LabelAssociationMessage:
+		label := map[string]interface{}{"Action": "remove", "ID": evt.LabelID, "JID": evt.JID.String(), "MessageID": evt.MessageID, "Timestamp": evt.Timestamp}
+		if evt.Action.GetLabeled() {
+			label["Action"] = "apply"
+		}
+		postmap["type"] = "Label"
+		postmap["label"] = label
+		dowebhook = 1
+	case *events.Contact, *events.Star, *events.DeleteForMe, *events.UnarchiveChatsSetting, *events.UserStatusMute:
+		// App state changes with no webhook
 	case *events.LoggedOut:
+		groupcache.Delete(fmt.Sprint(mycli.userID))
+		labelcache.Delete(fmt.Sprint(mycli.userID))
+		presencecache.Delete(fmt.Sprint(mycli.userID))
 		// log.Info().Str("reason", evt.Reason.String()).Msg("Logged out")
 		err = mycli.service.SetDisconnected(mycli.userID)
 
@@ -597,6 +790,87 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 		postmap["type"] = "ChatPresence"
 		dowebhook = 1
 		// log.Info().Str("state", fmt.Sprintf("%s", evt.State)).Str("media", fmt.Sprintf("%s", evt.Media)).Str("chat", evt.MessageSource.Chat.String()).Str("sender", evt.MessageSource.Sender.String()).Msg("Chat Presence received")
+	case *events.NewsletterJoin:
+		postmap["type"] = "Newsletter"
+		postmap["action"] = "join"
+		postmap["newsletter"] = evt.NewsletterMetadata
+		dowebhook = 1
+	case *events.NewsletterLeave:
+		postmap["type"] = "Newsletter"
+		postmap["action"] = "leave"
+		postmap["newsletter"] = map[string]interface{}{"ID": evt.ID.String(), "Role": evt.Role}
+		dowebhook = 1
+	case *events.NewsletterMuteChange:
+		postmap["type"] = "Newsletter"
+		postmap["action"] = "mute"
+		postmap["newsletter"] = map[string]interface{}{"ID": evt.ID.String(), "Mute": evt.Mute}
+		dowebhook = 1
+	case *events.GroupInfo:
+		if evt.Ephemeral != nil {
+			timer := uint32(0)
+			if evt.Ephemeral.IsEphemeral {
+				timer = evt.Ephemeral.DisappearingTimer
+			}
+			storeDisappearingTimer(mycli.userID, evt.JID, timer)
+		}
+		applyGroupChange(mycli.userID, mycli.WAClient.Store.ID, evt)
+		if requests := getJoinRequests(evt); len(requests) > 0 {
+			// Delivered on their own, the same change can also carry group changes
+			mycli.myEventHandler(&groupJoinRequests{GroupInfo: evt, requests: requests})
+		}
+		if changes := groupChanges(mycli.WAClient.Store.ID, evt); len(changes) > 0 {
+			group := map[string]interface{}{"JID": evt.JID.String(), "Timestamp": evt.Timestamp, "Changes": changes}
+			if evt.Sender != nil {
+				group["Sender"] = evt.Sender.String()
+			}
+			postmap["type"] = "Group"
+			postmap["group"] = group
+			dowebhook = 1
+		}
+	case *groupJoinRequests:
+		postmap["type"] = "JoinRequest"
+		postmap["joinRequests"] = evt.requests
+		dowebhook = 1
+	case *events.JoinedGroup:
+		updateCachedGroup(mycli.userID, evt.JID, func(*types.GroupInfo) *types.GroupInfo {
+			info := evt.GroupInfo
+			return &info
+		})
+		postmap["type"] = "Group"
+		postmap["group"] = map[string]interface{}{
+			"JID":       evt.JID.String(),
+			"Timestamp": time.Now(),
+			"Changes":   []map[string]interface{}{{"Action": "joined", "Reason": evt.Reason, "Type": evt.Type}},
+			"Info":      evt.GroupInfo,
+		}
+		dowebhook = 1
+	case *events.Picture:
+		// Only group photo changes are delivered, profile pictures of contacts change too often to be useful
+		if evt.JID.Server == types.GroupServer {
+			postmap["type"] = "Group"
+			postmap["group"] = map[string]interface{}{
+				"JID":       evt.JID.String(),
+				"Sender":    evt.Author.String(),
+				"Timestamp": evt.Timestamp,
+				"Changes":   []map[string]interface{}{{"Action": "photo", "Removed": evt.Remove, "PictureID": evt.PictureID}},
+			}
+			dowebhook = 1
+		}
+	case *events.PrivacySettings:
+		postmap["type"] = "PrivacySettings"
+		postmap["privacySettings"] = map[string]interface{}{"Settings": evt.NewSettings, "Changed": privacyChanges(evt)}
+		dowebhook = 1
+	case *events.Blocklist:
+		changes := make([]map[string]interface{}, 0, len(evt.Changes))
+		for _, change := range evt.Changes {
+			changes = append(changes, map[string]interface{}{"JID": change.JID.String(), "Action": change.Action})
+		}
+		// A modify action carries no changes, the whole list has to be fetched again
+		postmap["type"] = "Blocklist"
+		postmap["blocklist"] = map[string]interface{}{"Action": evt.Action, "Changes": changes}
+		dowebhook = 1
+	case *events.MediaRetry:
+		deliverMediaRetry(mycli.userID, evt)
 	case *events.CallOffer:
 		// log.Info().Str("event", fmt.Sprintf("%+v", evt)).Msg("Got call offer")
 	case *events.CallAccept: